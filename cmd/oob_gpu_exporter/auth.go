@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+	"github.com/firmus-public/oob_gpu_exporter/internal/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Successfully verified credentials, as comparing bcrypt hashes on every
+// scrape is expensive. The key includes the hash, so that entries of changed
+// passwords are not used.
+var authCacheMutex sync.Mutex
+var authCache = map[[sha256.Size]byte]bool{}
+
+// Returns a handler that requires the requests for the endpoint to be
+// authenticated by basic auth or a bearer token, like the basic_auth and
+// authorization settings of a Prometheus scrape configuration send them
+func authenticate(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rsp http.ResponseWriter, req *http.Request) {
+		auth := &config.Current().Auth
+		if !auth.Required(endpoint) || authenticated(auth, req) {
+			next(rsp, req)
+			return
+		}
+
+		log.Error("Received unauthenticated request from %s for %s", req.RemoteAddr, req.URL.Path)
+		if len(auth.BasicAuthUsers) > 0 {
+			rsp.Header().Set("WWW-Authenticate", `Basic realm="oob_gpu_exporter"`)
+		} else {
+			rsp.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(rsp, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+func authenticated(auth *config.AuthConfig, req *http.Request) bool {
+	if user, password, ok := req.BasicAuth(); ok {
+		hash, ok := auth.BasicAuthUsers[user]
+		return ok && verifyPassword(user, password, hash)
+	}
+
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	valid := false
+	for _, t := range auth.BearerTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func verifyPassword(user string, password string, hash string) bool {
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+
+	authCacheMutex.Lock()
+	cached := authCache[key]
+	authCacheMutex.Unlock()
+	if cached {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	authCacheMutex.Lock()
+	authCache[key] = true
+	authCacheMutex.Unlock()
+	return true
+}
+
+// Returns a handler that only accepts POST requests, which is required for
+// endpoints that change the state of the exporter
+func requirePost(next http.HandlerFunc) http.HandlerFunc {
+	return func(rsp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rsp.Header().Set("Allow", http.MethodPost)
+			http.Error(rsp, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next(rsp, req)
+	}
+}
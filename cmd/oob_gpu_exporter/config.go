@@ -1,6 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -9,94 +17,196 @@ import (
 	"github.com/firmus-public/oob_gpu_exporter/internal/log"
 )
 
-func ReloadConfig(filename string) {
-	cfg := config.NewConfig()
-	old := config.Config
+// Serializes the reloads triggered by the watchers, signals and requests
+var reloadMutex sync.Mutex
+
+// Watcher of the directories holding credentials, which follows the paths of
+// the current configuration
+var credentials struct {
+	mutex   sync.Mutex
+	watcher *fsnotify.Watcher
+	paths   map[string]bool
+}
+
+// Replaces the configuration with the one read anew, the collectors of all
+// targets whose settings changed or that no longer resolve to a host entry
+// are reset. The current configuration is kept if the new one is invalid.
+func ReloadConfig(filename string) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
 
 	log.Info("Configuration reload was triggered")
 
+	err := reloadConfig(filename)
+	if err != nil {
+		log.Error("%v", err)
+		collector.RecordReload(false)
+		return err
+	}
+
+	collector.RecordReload(true)
+	return nil
+}
+
+func reloadConfig(filename string) error {
+	cfg := config.NewConfig()
+
 	if len(filename) > 0 {
 		err := cfg.FromFile(filename)
 		if err != nil {
-			log.Error("Failed to %v", err)
-			return
+			return fmt.Errorf("failed to %v", err)
 		}
 	}
 
 	cfg.FromEnvironment()
 	err := cfg.Validate()
 	if err != nil {
-		log.Error("Invalid configuration: %v", err)
-		return
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	old := config.Current()
+	if cfg.ListenerChanged(old) {
+		log.Warn("Changes to address, port or tls.enabled require a restart")
+		cfg.Address = old.Address
+		cfg.Port = old.Port
+		cfg.TLS.Enabled = old.TLS.Enabled
 	}
 
-	old.Mutex.Lock()
-	defer old.Mutex.Unlock()
+	err = loadServerTLS(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to %v", err)
+	}
 
-	for k, v := range cfg.Hosts {
-		h, ok := old.Hosts[k]
-		if ok {
-			if h.Username != v.Username || h.Password != v.Password || h.Scheme != v.Scheme {
-				old.Hosts[k] = v
-				collector.Reset(k)
-			}
-		} else {
-			old.Hosts[k] = v
+	// Host entries can match several targets, so the targets of the existing
+	// collectors are resolved again to find the ones that changed
+	reset := []string{}
+	for _, t := range collector.Targets() {
+		before := old.HostConfig(t)
+		after := cfg.HostConfig(t)
+		if cfg.ClientChanged(old) || before == nil || after == nil || after.ClientChanged(before) {
+			reset = append(reset, t)
 		}
 	}
 
-	log.Info("Configuration reload was successful")
+	config.SetConfig(cfg)
+	collector.UpdateExporterMetrics()
+	watchCredentialPaths(cfg.CredentialPaths())
+
+	for _, t := range reset {
+		collector.Reset(t)
+	}
+
+	collector.UpdatePollers()
+
+	log.Info("Configuration reload was successful, reset %d collectors", len(reset))
+	return nil
 }
 
+// Watches the directory of the configuration file and reloads it once it did
+// not change for a second, so that a file being written is not read halfway.
+// The directory is watched instead of the file to also notice files that are
+// replaced, like a Kubernetes ConfigMap whose ..data link is swapped.
 func WatchConfig(filename string) {
-	lastReload := time.Now()
-
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Error("Failed to start file watcher: %v", err)
 		return
 	}
-	defer func(){
+	defer func() {
 		err := watcher.Close()
 		if err != nil {
 			log.Error("Failed to close file watcher: %v", err)
 		}
 	}()
 
-	err = watcher.Add(filename)
+	filename = filepath.Clean(filename)
+	err = watcher.Add(filepath.Dir(filename))
 	if err != nil {
 		log.Error("Failed to watch configuration file: %v", err)
 		return
 	}
 
+	// The file the configuration file links to, which changes when a link in
+	// its path is swapped
+	target, _ := filepath.EvalSymlinks(filename)
+
+	timer := time.NewTimer(0)
+	<-timer.C
+
 	for {
 		select {
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return
 			}
-			if time.Since(lastReload) < time.Second {
-				break // needed to deduplicate e.g. multiple write events
-			}
-			reload := false
-			if event.Has(fsnotify.Write) {
-				reload = true
-			} else if event.Has(fsnotify.Remove) {
-				err := watcher.Remove(event.Name)
-				if err != nil {
-					log.Error("Failed to remove file watcher: %v", err)
-					return
-				}
-				err = watcher.Add(filename)
-				if err != nil {
-					return
-				}
+			reload := filepath.Clean(event.Name) == filename
+			if t, _ := filepath.EvalSymlinks(filename); t != target {
+				target = t
 				reload = true
 			}
 			if reload {
-				lastReload = time.Now()
-				ReloadConfig(filename)
+				timer.Reset(time.Second) // deduplicates e.g. multiple write events
+			}
+		case <-timer.C:
+			//nolint:errcheck // logged by ReloadConfig
+			ReloadConfig(filename)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error("File watcher error: %v", err)
+		}
+	}
+}
+
+// Reloads the configuration on SIGHUP, which also works without a file
+func HandleSignals(filename string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		//nolint:errcheck // logged by ReloadConfig
+		ReloadConfig(filename)
+	}
+}
+
+// Watches the directories holding credentials and reloads the configuration
+// once they did not change for a second, as a mounted Kubernetes secret is
+// updated by several operations
+func WatchCredentials(filename string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("Failed to start file watcher: %v", err)
+		return
+	}
+	defer func() {
+		err := watcher.Close()
+		if err != nil {
+			log.Error("Failed to close file watcher: %v", err)
+		}
+	}()
+
+	credentials.mutex.Lock()
+	credentials.watcher = watcher
+	credentials.paths = map[string]bool{}
+	credentials.mutex.Unlock()
+
+	watchCredentialPaths(config.Current().CredentialPaths())
+
+	timer := time.NewTimer(0)
+	<-timer.C
+
+	for {
+		select {
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
 			}
+			timer.Reset(time.Second)
+		case <-timer.C:
+			log.Info("Credentials changed")
+			//nolint:errcheck // logged by ReloadConfig
+			ReloadConfig(filename)
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return
@@ -106,6 +216,49 @@ func WatchConfig(filename string) {
 	}
 }
 
+// Watches the given directories holding credentials instead of the ones of
+// the previous configuration. Missing directories are tried again on the next
+// reload.
+func watchCredentialPaths(paths []string) {
+	credentials.mutex.Lock()
+	defer credentials.mutex.Unlock()
+
+	if credentials.watcher == nil {
+		return
+	}
+
+	current := map[string]bool{}
+	for _, path := range paths {
+		current[path] = true
+		if credentials.paths[path] {
+			continue
+		}
+
+		err := credentials.watcher.Add(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Optional credential files may be in a missing directory
+			log.Debug("Not watching missing credentials directory: %s", path)
+			continue
+		} else if err != nil {
+			log.Error("Failed to watch credentials: %v", err)
+			continue
+		}
+		credentials.paths[path] = true
+	}
+
+	for path := range credentials.paths {
+		if current[path] {
+			continue
+		}
+
+		err := credentials.watcher.Remove(path)
+		if err != nil {
+			log.Debug("Failed to stop watching credentials directory %s: %v", path, err)
+		}
+		delete(credentials.paths, path)
+	}
+}
+
 func LoadConfig(filename string) {
 	cfg := config.NewConfig()
 
@@ -123,9 +276,89 @@ func LoadConfig(filename string) {
 		log.Fatal("Invalid configuration: %v", err)
 	}
 
+	err = loadServerTLS(cfg)
+	if err != nil {
+		log.Fatal("Failed to %v", err)
+	}
+
 	config.SetConfig(cfg)
+	collector.UpdateExporterMetrics()
+	collector.UpdatePollers()
+	collector.StartEviction()
+	collector.RecordReload(true)
 
 	if len(filename) > 0 {
 		go WatchConfig(filename)
 	}
+
+	go HandleSignals(filename)
+
+	// Credentials may be configured by a later reload
+	go WatchCredentials(filename)
+}
+
+// Prints the host entry the target resolves to and the resulting settings,
+// returns false if the configuration is invalid or no entry matches
+func CheckTarget(filename string, target string) bool {
+	cfg := config.NewConfig()
+
+	if len(filename) > 0 {
+		err := cfg.FromFile(filename)
+		if err != nil {
+			fmt.Printf("Failed to %v\n", err)
+			return false
+		}
+	}
+
+	cfg.FromEnvironment()
+	err := cfg.Validate()
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		return false
+	}
+
+	if !cfg.TargetAllowed(target) {
+		fmt.Printf("Target %s is not allowed\n", target)
+		return false
+	}
+
+	key := cfg.ResolveHost(target)
+	host := cfg.HostConfig(target)
+	if host == nil {
+		fmt.Printf("Target %s does not match any host entry\n", target)
+		return false
+	}
+
+	// Show the effective settings including the global defaults
+	timeout := host.Timeout
+	if timeout == 0 {
+		timeout = cfg.Timeout
+	}
+	concurrency := host.Concurrency
+	if concurrency == 0 {
+		concurrency = cfg.Concurrency
+	}
+	metrics := host.Metrics
+	if metrics == nil {
+		metrics = cfg.Metrics
+	}
+
+	if key == "" {
+		fmt.Printf("Target %s has credentials in %s\n", target, cfg.Credentials)
+	} else {
+		fmt.Printf("Target %s resolves to host entry %q (%s)\n", target, key, config.HostKindName(config.HostKind(key)))
+	}
+	fmt.Printf("  username:             %s\n", host.Username)
+	fmt.Printf("  scheme:               %s\n", host.Scheme)
+	fmt.Printf("  timeout:              %d\n", timeout)
+	fmt.Printf("  concurrency:          %d\n", concurrency)
+	fmt.Printf("  poll_interval:        %d\n", host.PollInterval)
+	fmt.Printf("  metrics:              %s\n", metrics)
+	fmt.Printf("  proxy_url:            %s\n", host.ProxyURL)
+	fmt.Printf("  ca_file:              %s\n", host.CAFile)
+	fmt.Printf("  insecure_skip_verify: %v\n", host.SkipVerify())
+	fmt.Printf("  server_name:          %s\n", host.ServerName)
+	fmt.Printf("  fingerprint:          %s\n", host.Fingerprint)
+	fmt.Printf("  trust_on_first_use:   %v\n", host.TrustOnFirstUse)
+	return true
 }
@@ -2,21 +2,28 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
+	"crypto/subtle"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/firmus-public/oob_gpu_exporter/internal/collector"
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
 	"github.com/firmus-public/oob_gpu_exporter/internal/log"
 	"github.com/firmus-public/oob_gpu_exporter/internal/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
 	contentTypeHeader     = "Content-Type"
 	contentEncodingHeader = "Content-Encoding"
 	acceptEncodingHeader  = "Accept-Encoding"
+	scrapeTimeoutHeader   = "X-Prometheus-Scrape-Timeout-Seconds"
 )
 
 var gzipPool = sync.Pool{
@@ -30,7 +37,10 @@ const landingPageTemplate = `<html lang="en">
 <body style="font-family: sans-serif">
 <h2>Out-of-band GPU Exporter</h2>
 <div>Build information: version=%s revision=%s</div>
-<ul><li><a href="/metrics">Metrics</a> (needs <code>target</code> parameter)</li></ul>
+<ul>
+<li><a href="/metrics">Metrics</a> (needs <code>target</code> parameter)</li>
+<li><a href="/exporter_metrics">Exporter metrics</a></li>
+</ul>
 </body>
 </html>
 `
@@ -44,6 +54,11 @@ func rootHandler(rsp http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func exporterMetricsHandler(rsp http.ResponseWriter, req *http.Request) {
+	// The registry is created anew when the metrics prefix is reloaded
+	promhttp.HandlerFor(collector.ExporterRegistry(), promhttp.HandlerOpts{}).ServeHTTP(rsp, req)
+}
+
 func healthHandler(rsp http.ResponseWriter, req *http.Request) {
 	// just return a simple 200 for now
 }
@@ -61,6 +76,47 @@ func resetHandler(rsp http.ResponseWriter, req *http.Request) {
 	collector.Reset(target)
 }
 
+// Returns a handler reloading the configuration on POST requests, which
+// requires the reload token as bearer token unless the endpoint is
+// authenticated by the auth section, and is disabled if neither is configured
+func reloadHandler(filename string) http.HandlerFunc {
+	return requirePost(func(rsp http.ResponseWriter, req *http.Request) {
+		cfg := config.Current()
+		if !cfg.Auth.Required("/-/reload") {
+			if !reloadAuthorized(rsp, req, cfg.ReloadToken) {
+				return
+			}
+		}
+
+		log.Debug("Handling reload request from %s", req.RemoteAddr)
+
+		err := ReloadConfig(filename)
+		if err != nil {
+			http.Error(rsp, fmt.Sprintf("Reload failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// Returns whether the request has the reload token as bearer token, otherwise
+// the request is rejected
+func reloadAuthorized(rsp http.ResponseWriter, req *http.Request, token string) bool {
+	if token == "" {
+		http.Error(rsp, "Reload endpoint is disabled without reload_token", http.StatusForbidden)
+		return false
+	}
+
+	auth, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+		log.Error("Received reload request from %s with invalid token", req.RemoteAddr)
+		rsp.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rsp, "Invalid reload token", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
 func metricsHandler(rsp http.ResponseWriter, req *http.Request) {
 	// Config is reloaded in the background watcher, just use current config
 	target := req.URL.Query().Get("target")
@@ -70,27 +126,41 @@ func metricsHandler(rsp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	log.Debug("Handling request from %s for host %s", req.Host, target)
-
-	c, err := collector.GetCollector(target)
-	if err != nil {
-		errorMsg := fmt.Sprintf("Error instantiating metrics collector for host %s: %v", target, err)
-		log.Error("%v", errorMsg)
-		http.Error(rsp, errorMsg, http.StatusInternalServerError)
+	// Reject unknown targets before connecting to them in strict mode
+	if !config.TargetAllowed(target) {
+		log.Error("Rejected request from %s for host %s which is not allowed", req.RemoteAddr, target)
+		collector.RecordRejectedTarget()
+		http.Error(rsp, fmt.Sprintf("Target %s is not allowed", target), http.StatusForbidden)
 		return
 	}
 
-	log.Debug("Collecting metrics for host %s", target)
+	log.Debug("Handling request from %s for host %s", req.Host, target)
 
-	metrics, err := c.Gather()
-	if err != nil {
-		errorMsg := fmt.Sprintf("Error collecting metrics for host %s: %v", target, err)
-		log.Error("%v", errorMsg)
-		http.Error(rsp, errorMsg, http.StatusInternalServerError)
-		return
-	}
+	var err error
+	start := time.Now()
 
-	log.Debug("Metrics for host %s collected", target)
+	// Hosts polled in the background are served from their last snapshot,
+	// without a recent one they are reported as down
+	metrics, polled := collector.GetSnapshot(target)
+	if polled {
+		if metrics == "" {
+			log.Error("No recent metrics available for host %s", target)
+			metrics = collector.UnavailableMetrics(start)
+		}
+	} else {
+		log.Debug("Collecting metrics for host %s", target)
+
+		ctx, cancel := scrapeContext(req)
+		defer cancel()
+
+		_, metrics, err = collector.GatherTarget(ctx, target)
+		if err != nil {
+			log.Error("Error collecting metrics for host %s: %v", target, err)
+			metrics = collector.UnavailableMetrics(start)
+		} else {
+			log.Debug("Metrics for host %s collected", target)
+		}
+	}
 
 	header := rsp.Header()
 	header.Set(contentTypeHeader, "text/plain")
@@ -121,6 +191,23 @@ func metricsHandler(rsp http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Returns the context of a scrape, which is cancelled when the client goes
+// away or the scrape timeout of Prometheus less the offset has passed
+func scrapeContext(req *http.Request) (context.Context, context.CancelFunc) {
+	timeout, err := strconv.ParseFloat(req.Header.Get(scrapeTimeoutHeader), 64)
+	if err != nil || timeout <= 0 {
+		return context.WithCancel(req.Context())
+	}
+
+	// Keep the offset from using up short timeouts completely
+	offset := config.Current().ScrapeTimeoutOffset
+	if offset < timeout {
+		timeout -= offset
+	}
+
+	return context.WithTimeout(req.Context(), time.Duration(timeout*float64(time.Second)))
+}
+
 // gzipAccepted returns whether the client will accept gzip-encoded content.
 func gzipAccepted(header http.Header) bool {
 	a := header.Get(acceptEncodingHeader)
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/firmus-public/oob_gpu_exporter/internal/collector"
 	"github.com/firmus-public/oob_gpu_exporter/internal/config"
 	"github.com/firmus-public/oob_gpu_exporter/internal/log"
 	"github.com/firmus-public/oob_gpu_exporter/internal/version"
@@ -16,13 +23,22 @@ func main() {
 	var verbose bool
 	var debug bool
 	var configFile string
+	var checkTarget string
 	var err error
 
 	flag.BoolVar(&verbose, "verbose", false, "Enable more verbose logging")
 	flag.BoolVar(&debug, "debug", false, "Dump JSON response from Redfish requests (only for debugging purpose)")
 	flag.StringVar(&configFile, "config", "/etc/oob_gpu_exporter/config.yml", "Path to out-of-band GPU exporter configuration file")
+	flag.StringVar(&checkTarget, "check-target", "", "Print the host entry the given target resolves to and exit")
 	flag.Parse()
 
+	if checkTarget != "" {
+		if !CheckTarget(configFile, checkTarget) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.Info("Build information: version=%s revision=%s", version.Version, version.Revision)
 	LoadConfig(configFile)
 
@@ -35,23 +51,63 @@ func main() {
 		log.SetLevel(log.LevelDebug)
 	}
 
-	http.HandleFunc("/metrics", metricsHandler)
-	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/reset", resetHandler)
-	http.HandleFunc("/", rootHandler)
+	http.HandleFunc("/metrics", authenticate("/metrics", metricsHandler))
+	http.HandleFunc("/exporter_metrics", authenticate("/exporter_metrics", exporterMetricsHandler))
+	http.HandleFunc("/health", authenticate("/health", healthHandler))
+	http.HandleFunc("/reset", authenticate("/reset", requirePost(resetHandler)))
+	http.HandleFunc("/-/reload", authenticate("/-/reload", reloadHandler(configFile)))
+	http.HandleFunc("/", authenticate("/", rootHandler))
 
-	port := fmt.Sprintf("%d", config.Config.Port)
-	host := strings.Trim(config.Config.Address, "[]")
+	// The listener settings are kept on reload
+	cfg := config.Current()
+	port := fmt.Sprintf("%d", cfg.Port)
+	host := strings.Trim(cfg.Address, "[]")
 	bind := net.JoinHostPort(host, port)
-	log.Info("Server listening on %s (TLS: %v)", bind, config.Config.TLS.Enabled)
+	log.Info("Server listening on %s (TLS: %v)", bind, cfg.TLS.Enabled)
 
-	if config.Config.TLS.Enabled {
-		err = http.ListenAndServeTLS(bind, config.Config.TLS.CertFile, config.Config.TLS.KeyFile, nil)
+	server := &http.Server{Addr: bind}
+	go shutdownOnSignal(server)
+
+	if cfg.TLS.Enabled {
+		server.TLSConfig = &tls.Config{GetConfigForClient: getServerTLSConfig}
+		err = server.ListenAndServeTLS("", "")
 	} else {
-		err = http.ListenAndServe(bind, nil)
+		err = server.ListenAndServe()
 	}
 
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal("%v", err)
 	}
+
+	// Wait for the shutdown to complete, which exits the process
+	select {}
+}
+
+// Shuts down the server on SIGINT or SIGTERM. Ongoing scrapes are completed
+// and the sessions of all hosts are deleted, unless this takes longer than
+// the shutdown timeout.
+func shutdownOnSignal(server *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	timeout := time.Duration(config.Current().ShutdownTimeout) * time.Second
+	log.Info("Received %v, shutting down within %v", sig, timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Error("Failed to complete ongoing requests: %v", err)
+	}
+
+	err = collector.Shutdown(ctx)
+	if err != nil {
+		log.Error("Failed to delete all sessions: %v", err)
+		os.Exit(1)
+	}
+
+	log.Info("Shutdown complete")
+	os.Exit(0)
 }
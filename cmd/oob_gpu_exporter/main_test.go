@@ -1,57 +1,1401 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 
 	// "os"
 	"os/exec"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-func TestDell(t *testing.T) {
+func TestDell(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+    resp := getMetrics(t, server)
+
+    assert_equal(t, "dell_expected.txt", resp)
+}
+
+func TestSYS421GETNRT(t *testing.T) {
+	server := NewTestServer(t, "SYS-421GE-TNRT")
+	defer server.Close()
+
+    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+    resp := getMetrics(t, server)
+
+    assert_equal(t, "SYS-421GE-TNRT_expected.txt", resp)
+}
+
+func TestAS4124GONARTPlus(t *testing.T) {
+	server := NewTestServer(t, "AS -4124GO-NART+")
+	defer server.Close()
+
+    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+    resp := getMetrics(t, server)
+
+    assert_equal(t, "AS -4124GO-NART+_expected.txt", resp)
+}
+
+func TestXD670(t *testing.T) {
+	server := NewTestServer(t, "XD670")
+	defer server.Close()
+
+    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+    resp := getMetrics(t, server)
+
+    assert_equal(t, "XD670_expected.txt", resp)
+}
+
+func TestXD670PCIeDevices(t *testing.T) {
+	// Older iLO firmware only lists the CPUs as processors
+	files := fileHandler(filepath.Join("testdata", "XD670"))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redfish/v1/Systems/1/Processors" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"@odata.id": "/redfish/v1/Systems/1/Processors", "Members": [`+
+				`{"@odata.id": "/redfish/v1/Systems/1/Processors/1"}, {"@odata.id": "/redfish/v1/Systems/1/Processors/2"}]}`)
+			return
+		}
+		files(w, r)
+	}))
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+	resp, err := get("http://localhost:9347/metrics?target=" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+
+	assert_equal(t, "XD670_pcie_expected.txt", resp)
+}
+
+func TestSR675V3(t *testing.T) {
+	server := NewTestServer(t, "SR675V3")
+	defer server.Close()
+
+    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+    resp := getMetrics(t, server)
+
+    assert_equal(t, "SR675V3_expected.txt", resp)
+}
+
+func TestGeneric(t *testing.T) {
+	server := NewTestServer(t, "generic")
+	defer server.Close()
+
+    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+    resp := getMetrics(t, server)
+
+    assert_equal(t, "generic_expected.txt", resp)
+}
+
+func TestHGXH100(t *testing.T) {
+	server := NewTestServer(t, "HGX-H100")
+	defer server.Close()
+
+    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+    resp := getMetrics(t, server)
+
+    assert_equal(t, "HGX-H100_expected.txt", resp)
+}
+
+func TestHGXH100Host(t *testing.T) {
+	server := NewTestServer(t, "HGX-H100-host")
+	defer server.Close()
+
+    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+    resp := getMetrics(t, server)
+
+    assert_equal(t, "HGX-H100-host_expected.txt", resp)
+}
+
+func TestHGXH100HostFailedSystem(t *testing.T) {
+	// The GPUs of the other systems are still collected
+	files := fileHandler(filepath.Join("testdata", "HGX-H100-host"))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redfish/v1/Systems/HGX_Baseboard_0/Processors" {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		files(w, r)
+	}))
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+	resp, err := get("http://localhost:9347/metrics?target=" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+
+	want := `oob_gpu_collection_errors{chassis="HGX_Chassis_0",id="HGX_Baseboard_0",resource="system",system="HGX_Baseboard_0"} 1`
+	if !strings.Contains(resp, want) {
+		t.Errorf("Metrics do not contain %q:\n%s", want, resp)
+	}
+	if !strings.Contains(resp, `oob_gpu_info{`) || !strings.Contains(resp, `system="system"`) {
+		t.Errorf("Metrics do not contain the GPUs of the other system:\n%s", resp)
+	}
+}
+
+func TestHGXH100HostUndiscoveredSystem(t *testing.T) {
+	// The system is discovered again on the next scrape
+	files := fileHandler(filepath.Join("testdata", "HGX-H100-host"))
+	var failing atomic.Bool
+	failing.Store(true)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redfish/v1/Systems/HGX_Baseboard_0" && failing.Load() {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		files(w, r)
+	}))
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+	url := "http://localhost:9347/metrics?target=" + server.Listener.Addr().String()
+	resp, err := get(url)
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+
+	for _, want := range []string{
+		`id="HGX_Baseboard_0",resource="system",system="HGX_Baseboard_0"} 1`,
+		`oob_gpu_exporter_up 0`,
+		`system="system"`,
+	} {
+		if !strings.Contains(resp, want) {
+			t.Errorf("Metrics do not contain %q:\n%s", want, resp)
+		}
+	}
+
+	failing.Store(false)
+	resp, err = get(url)
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+
+	for _, want := range []string{
+		`oob_gpu_exporter_up 1`,
+		`system="HGX_Baseboard_0"`,
+	} {
+		if !strings.Contains(resp, want) {
+			t.Errorf("Metrics do not contain %q after the system recovered:\n%s", want, resp)
+		}
+	}
+	if strings.Contains(resp, `resource="system"`) {
+		t.Errorf("Metrics still report the recovered system as failed:\n%s", resp)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	// The NVLink ports are walked in pools nested in the pool of the GPUs,
+	// which must not exceed the concurrency of the host together
+	files := fileHandler(filepath.Join("testdata", "HGX-H100"))
+	var inFlight, peak atomic.Int64
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		files(w, r)
+	}))
+	defer server.Close()
+
+	configFile := writeConfig(t, t.TempDir(), "concurrency: 2", "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	resp, err := get("http://localhost:9347/metrics?target=" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	if !strings.Contains(resp, "oob_gpu_exporter_up 1\n") {
+		t.Fatalf("Scrape failed:\n%s", resp)
+	}
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d requests were in flight at the same time, expected at most 2", p)
+	}
+}
+
+func TestCAFile(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	// Verify the certificate of the test server instead of skipping it
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.server.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	configFile := writeConfig(t, dir, "", fmt.Sprintf("ca_file: %s\nserver_name: example.com", caFile))
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	resp := getMetrics(t, server)
+
+	assert_equal(t, "dell_expected.txt", resp)
+}
+
+func TestFingerprintPinning(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	sum := sha256.Sum256(server.server.Certificate().Raw)
+	fingerprint := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	configFile := writeConfig(t, t.TempDir(), "", "fingerprint: "+fingerprint)
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	resp := getMetrics(t, server)
+
+	assert_equal(t, "dell_expected.txt", resp)
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	dir := t.TempDir()
+	fingerprintsFile := filepath.Join(dir, "fingerprints.yml")
+	configFile := writeConfig(t, dir, "fingerprints_file: "+fingerprintsFile, "trust_on_first_use: true")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	resp := getMetrics(t, server)
+
+	assert_equal(t, "dell_expected.txt", resp)
+
+	// The fingerprint of the certificate is stored on first use
+	sum := sha256.Sum256(server.server.Certificate().Raw)
+	expected := fmt.Sprintf("%s: %s\n", net.JoinHostPort(server.Host, server.Port), hex.EncodeToString(sum[:]))
+
+	fingerprints, err := readTestFile(fingerprintsFile)
+	if err != nil {
+		t.Fatalf("Failed to read fingerprints file: %v", err)
+	}
+	if fingerprints != expected {
+		t.Fatalf("Fingerprints do not match.\nGot:\n%s\nExpected:\n%s", fingerprints, expected)
+	}
+}
+
+func TestMetricsSelection(t *testing.T) {
+	server := NewTestServer(t, "generic")
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config_metrics.yml")
+	defer exporter.Stop()
+
+	resp := getMetrics(t, server)
+
+	assert_equal(t, "generic_metrics_expected.txt", resp)
+}
+
+func TestMetricsSelectionThermal(t *testing.T) {
+	// The processors and PCIe devices are not walked for the thermal metrics
+	configFile := writeConfig(t, t.TempDir(), "metrics:\n  thermal: true", "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	for _, tc := range []struct {
+		name  string
+		walks []string
+	}{
+		{"dell", []string{"/redfish/v1/Systems/System.Embedded.1/Processors"}},
+		{"XD670", []string{"/redfish/v1/Systems/1/Processors", "/redfish/v1/Chassis/1/PCIeDevices"}},
+		{"SYS-421GE-TNRT", []string{"/redfish/v1/Chassis/1/PCIeDevices"}},
+	} {
+		files := fileHandler(filepath.Join("testdata", tc.name))
+		var walked atomic.Int64
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, walk := range tc.walks {
+				if strings.HasPrefix(r.URL.Path, walk) {
+					walked.Add(1)
+				}
+			}
+			files(w, r)
+		}))
+
+		resp, err := get("http://localhost:9347/metrics?target=" + server.Listener.Addr().String())
+		server.Close()
+		if err != nil {
+			t.Fatalf("Failed to get metrics of %s: %v", tc.name, err)
+		}
+
+		if !strings.Contains(resp, "oob_gpu_primary_gpu_temperature_celsius{") {
+			t.Errorf("Metrics of %s do not contain the GPU temperatures:\n%s", tc.name, resp)
+		}
+		if n := walked.Load(); n != 0 {
+			t.Errorf("%d requests of %s were made for %v with only thermal metrics selected", n, tc.name, tc.walks)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	auth := fmt.Sprintf("auth:\n  basic_auth_users:\n    prometheus: %s\n  bearer_tokens: [token]", hash)
+	configFile := writeConfig(t, t.TempDir(), auth, "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	metrics := "http://localhost:9347/metrics?target=" + net.JoinHostPort(server.Host, server.Port)
+	for _, tc := range []struct {
+		method   string
+		url      string
+		user     string
+		password string
+		token    string
+		code     int
+	}{
+		{http.MethodGet, metrics, "", "", "", http.StatusUnauthorized},
+		{http.MethodGet, metrics, "prometheus", "wrong", "", http.StatusUnauthorized},
+		{http.MethodGet, metrics, "other", "secret", "", http.StatusUnauthorized},
+		{http.MethodGet, metrics, "prometheus", "secret", "", http.StatusOK},
+		{http.MethodGet, metrics, "", "", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, metrics, "", "", "token", http.StatusOK},
+		{http.MethodGet, "http://localhost:9347/health", "", "", "", http.StatusOK},
+		{http.MethodGet, "http://localhost:9347/reset?target=x", "", "", "token", http.StatusMethodNotAllowed},
+		{http.MethodPost, "http://localhost:9347/reset?target=x", "", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "http://localhost:9347/reset?target=x", "", "", "token", http.StatusOK},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.password)
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to request %s: %v", tc.url, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.code {
+			t.Fatalf("%s %s as %q with token %q returned %d, expected %d", tc.method, tc.url, tc.user, tc.token, resp.StatusCode, tc.code)
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newCertificate(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	newCertificate(t, dir, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "exporter"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	newCertificate(t, dir, "prometheus", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "prometheus"},
+		DNSNames:    []string{"prometheus.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	newCertificate(t, dir, "other", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "other"},
+		DNSNames:    []string{"other.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	// Clients without certificate are rejected by the allowed names, even if
+	// the certificate is optional otherwise
+	for _, authType := range []string{"RequireAndVerifyClientCert", "VerifyClientCertIfGiven"} {
+		tlsOptions := fmt.Sprintf("tls:\n  enabled: true\n  cert_file: %[1]s/server.pem\n  key_file: %[1]s/server.key\n"+
+			"  client_ca_file: %[1]s/ca.pem\n  client_auth_type: %[2]s\n  client_allowed_sans: [prometheus.example.com]\n  min_version: TLS13", dir, authType)
+		configFile := writeConfig(t, dir, tlsOptions, "")
+
+		exporter := NewOOBGPUExporter(t, configFile)
+		checkClientCertificates(t, dir, roots)
+		exporter.Stop()
+	}
+}
+
+// checkClientCertificates checks that only the client certificate with an
+// allowed name is accepted.
+func checkClientCertificates(t *testing.T, dir string, roots *x509.CertPool) {
+	for _, tc := range []struct {
+		client string
+		ok     bool
+	}{
+		{"", false},
+		{"other", false},
+		{"prometheus", true},
+	} {
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if tc.client != "" {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, tc.client+".pem"), filepath.Join(dir, tc.client+".key"))
+			if err != nil {
+				t.Fatalf("Failed to load client certificate: %v", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		resp, err := client.Get("https://127.0.0.1:9347/health")
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tc.ok {
+			t.Fatalf("Request with client certificate %q returned error %v, expected success %v", tc.client, err, tc.ok)
+		}
+	}
+}
+
+func TestStrictTargets(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	configFile := writeConfig(t, t.TempDir(), "strict_targets: true\nallowed_targets: [127.0.0.0/8]", "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	// Targets that are not allowed are rejected before connecting to them
+	resp, err := http.Get("http://localhost:9347/metrics?target=192.0.2.1")
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Request for target that is not allowed returned %d, expected %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	assert_equal(t, "dell_expected.txt", getMetrics(t, server))
+
+	metrics, err := get("http://localhost:9347/exporter_metrics")
+	if err != nil {
+		t.Fatalf("Failed to get exporter metrics: %v", err)
+	}
+	if !strings.Contains(metrics, "oob_gpu_exporter_rejected_targets_total 1\n") {
+		t.Fatalf("Rejected target was not counted.\nGot:\n%s", metrics)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	// Exact entries take precedence over CIDR ranges, regular expressions, globs and the default,
+	// which except for exact entries with a port match the host of targets with a port
+	targets := []string{"10.20.1.5", "10.20.1.6:443", "10.20.9.1", "bmc-12.dc2.example.com:8443", "a.bmc.dc1.example.com:443", "[2001:db8::1]:443", "other"}
+
+	resp := ""
+	for _, target := range targets {
+		out, err := exec.Command("go", "run", ".", "-config", "testdata/config_patterns.yml", "-check-target", target).Output()
+		if err != nil {
+			t.Fatalf("Failed to check target %s: %v", target, err)
+		}
+		resp += string(out)
+	}
+
+	expected, err := readTestFile("testdata", "check_target_expected.txt")
+	if err != nil {
+		t.Fatalf("Failed to read expected response: %v", err)
+	}
+	if resp != expected {
+		t.Fatalf("Resolved targets do not match expected content.\nGot:\n%s\nExpected:\n%s", resp, expected)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets")
+	files := map[string]string{
+		"password":        "frompassword\n",
+		"node":            "nodeuser=nodepass\n",
+		"secrets/bmc-1":   "diruser=dirpass\n",
+		"secrets/.hidden": "ignored",
+		"ca.pem":          "",
+	}
+	if err := os.Mkdir(secrets, 0755); err != nil {
+		t.Fatalf("Failed to create credentials directory: %v", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	cfg := "credentials_dir: " + secrets + "\n"
+	cfg += "hosts:\n"
+	cfg += "  default:\n    username: ${TEST_BMC_USER}\n    password_file: " + filepath.Join(dir, "password") + "\n"
+	cfg += "  node.example.com:\n    username: root\n    password: calvin\n    credentials_file: " + filepath.Join(dir, "${TEST_NODE_NAME}") + "\n"
+	configFile := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(configFile, []byte(cfg), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	// Credentials from the directory take precedence over the host entry
+	expected := map[string]string{
+		"bmc-1:443":        "diruser",
+		"node.example.com": "nodeuser",
+		"other":            "envuser",
+	}
+	for target, username := range expected {
+		cmd := exec.Command("go", "run", ".", "-config", configFile, "-check-target", target)
+		cmd.Env = append(os.Environ(), "TEST_BMC_USER=envuser", "TEST_NODE_NAME=node")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("Failed to check target %s: %v", target, err)
+		}
+		if !strings.Contains(string(out), "username:             "+username+"\n") {
+			t.Fatalf("Unexpected username for target %s, expected %s:\n%s", target, username, out)
+		}
+	}
+
+	// Targets without host entry use the global CA file
+	cfg = "credentials_dir: " + secrets + "\nca_file: " + filepath.Join(dir, "ca.pem") + "\n"
+	if err := os.WriteFile(configFile, []byte(cfg), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	out, err := exec.Command("go", "run", ".", "-config", configFile, "-check-target", "bmc-1:443").Output()
+	if err != nil {
+		t.Fatalf("Failed to check target bmc-1:443: %v", err)
+	}
+	if !strings.Contains(string(out), "insecure_skip_verify: false\n") {
+		t.Fatalf("Certificate of target bmc-1:443 is not verified:\n%s", out)
+	}
+}
+
+func TestCredentialsReload(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	// The credentials directory is only configured by a reload
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets")
+	if err := os.Mkdir(secrets, 0700); err != nil {
+		t.Fatalf("Failed to create credentials directory: %v", err)
+	}
+	configFile := filepath.Join(dir, "config.yml")
+	cfg := "port: 9347\nmetrics_prefix: %s\nstrict_targets: true\n%shosts:\n  default:\n    username: dummy\n    password: dummy\n"
+	if err := os.WriteFile(configFile, []byte(fmt.Sprintf(cfg, "oob", "")), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	status := func() int {
+		resp, err := http.Get("http://localhost:9347/metrics?target=" + net.JoinHostPort(server.Host, server.Port))
+		if err != nil {
+			t.Fatalf("Failed to get metrics: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := status(); code != http.StatusForbidden {
+		t.Fatalf("Target without credentials returned %d, expected 403", code)
+	}
+
+	// The prefix tells when the reload of the configuration file is done
+	if err := os.WriteFile(configFile, []byte(fmt.Sprintf(cfg, "reloaded", "credentials_dir: "+secrets+"\n")), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if !eventually(func() bool {
+		resp, _ := get("http://localhost:9347/exporter_metrics")
+		return strings.Contains(resp, "reloaded_gpu_exporter_config_last_reload_successful 1")
+	}) {
+		t.Fatalf("Configuration was not reloaded")
+	}
+
+	// Credentials written to the new directory are picked up by its watcher
+	if err := os.WriteFile(filepath.Join(secrets, server.Host), []byte("user=pass\n"), 0600); err != nil {
+		t.Fatalf("Failed to write credentials: %v", err)
+	}
+	if !eventually(func() bool { return status() == http.StatusOK }) {
+		t.Fatalf("Credentials in the reloaded credentials directory were not picked up")
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	// Reserve a port that nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	target := listener.Addr().String()
+	listener.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+	resp, err := get("http://localhost:9347/metrics?target=" + target)
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+
+	assert_equal(t, "unreachable_expected.txt", resp)
+}
+
+func TestUnavailable(t *testing.T) {
+	// Reserve a port that nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	polled := listener.Addr().String()
+	listener.Close()
+
+	// The polled target has no snapshot and the other one no credentials
+	dir := t.TempDir()
+	cfg := "port: 9347\nmetrics_prefix: oob\n"
+	cfg += "hosts:\n  " + polled + ":\n    username: dummy\n    password: dummy\n    poll_interval: 60\n"
+	configFile := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(configFile, []byte(cfg), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	for _, target := range []string{polled, "nocredentials"} {
+		resp, err := get("http://localhost:9347/metrics?target=" + target)
+		if err != nil {
+			t.Fatalf("Failed to get metrics of %s: %v", target, err)
+		}
+		if !strings.Contains(resp, "oob_gpu_exporter_up 0\n") {
+			t.Fatalf("Target %s is not reported as down.\nGot:\n%s", target, resp)
+		}
+	}
+}
+
+func TestExporterMetrics(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+	getMetrics(t, server)
+
+	resp, err := get("http://localhost:9347/exporter_metrics")
+	if err != nil {
+		t.Fatalf("Failed to get exporter metrics: %v", err)
+	}
+
+	// Requests are recorded by the resource class of their path
+	target := net.JoinHostPort(server.Host, server.Port)
+	for _, expected := range []string{
+		fmt.Sprintf(`oob_gpu_exporter_redfish_responses_total{code="200",resource="DellGPUSensors",target="%s"} 1`, target),
+		fmt.Sprintf(`oob_gpu_exporter_redfish_responses_total{code="404",resource="Sessions",target="%s"} 1`, target),
+		fmt.Sprintf(`oob_gpu_exporter_redfish_request_duration_seconds_count{resource="ServiceRoot",target="%s"} 1`, target),
+	} {
+		if !strings.Contains(resp, expected) {
+			t.Fatalf("Exporter metrics do not contain %q.\nGot:\n%s", expected, resp)
+		}
+	}
+
+	// The counters are kept by a reload that does not change the prefix
+	exporter.Signal(syscall.SIGHUP)
+	time.Sleep(300 * time.Millisecond)
+
+	resp, err = get("http://localhost:9347/exporter_metrics")
+	if err != nil {
+		t.Fatalf("Failed to get exporter metrics: %v", err)
+	}
+	expected := fmt.Sprintf(`oob_gpu_exporter_redfish_responses_total{code="200",resource="DellGPUSensors",target="%s"} 1`, target)
+	if !strings.Contains(resp, expected) {
+		t.Fatalf("Exporter metrics were reset by reload.\nGot:\n%s", resp)
+	}
+
+	// The series of a target are deleted together with its collector
+	reset, err := http.Post("http://localhost:9347/reset?target="+target, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to reset target: %v", err)
+	}
+	reset.Body.Close()
+
+	label := fmt.Sprintf(`target="%s"`, target)
+	if !eventually(func() bool {
+		resp, _ = get("http://localhost:9347/exporter_metrics")
+		return resp != "" && !strings.Contains(resp, label)
+	}) {
+		t.Fatalf("Exporter metrics of reset target were not deleted.\nGot:\n%s", resp)
+	}
+}
+
+func TestReload(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	dir := t.TempDir()
+	configFile := writeConfig(t, dir, "", "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	getMetrics(t, server)
+
+	// The configuration is reloaded a second after it was written
+	reload := func(cfg string) string {
+		if err := os.WriteFile(configFile, []byte(cfg), 0644); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		time.Sleep(1500 * time.Millisecond)
+
+		resp, err := get("http://localhost:9347/exporter_metrics")
+		if err != nil {
+			t.Fatalf("Failed to get exporter metrics: %v", err)
+		}
+		return resp
+	}
+
+	// The collector of the target is rebuilt with the new metrics prefix
+	resp := reload("port: 9347\nmetrics_prefix: reloaded\nhosts:\n  default:\n    username: dummy\n    password: dummy\n")
+	if !strings.Contains(resp, "reloaded_gpu_exporter_config_last_reload_successful 1") {
+		t.Fatalf("Reload was not successful.\nGot:\n%s", resp)
+	}
+	if !strings.Contains(getMetrics(t, server), "reloaded_gpu_num_gpus") {
+		t.Fatalf("Metrics prefix was not reloaded")
+	}
+
+	// An invalid configuration is not applied
+	resp = reload("port: 9347\nmetrics_prefix: invalid\nhosts:\n")
+	if !strings.Contains(resp, "reloaded_gpu_exporter_config_last_reload_successful 0") {
+		t.Fatalf("Reload of invalid configuration was not reported.\nGot:\n%s", resp)
+	}
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	configFile := writeConfig(t, dir, "reload_token: secret", "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+
+	reload := func(method string, auth string, code int) {
+		req, err := http.NewRequest(method, "http://localhost:9347/-/reload", nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to request reload: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != code {
+			t.Fatalf("Reload with %s and authorization %q returned %d, expected %d", method, auth, resp.StatusCode, code)
+		}
+	}
+
+	reload(http.MethodGet, "Bearer secret", http.StatusMethodNotAllowed)
+	reload(http.MethodPost, "", http.StatusUnauthorized)
+	reload(http.MethodPost, "Bearer wrong", http.StatusUnauthorized)
+	reload(http.MethodPost, "secret", http.StatusUnauthorized)
+	reload(http.MethodPost, "Bearer secret", http.StatusOK)
+	exporter.Stop()
+
+	// Listed in the auth section the endpoint takes its credentials instead
+	configFile = writeConfig(t, dir, "auth:\n  bearer_tokens: [scrape]\n  endpoints: [/-/reload]", "")
+	exporter = NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	reload(http.MethodGet, "", http.StatusUnauthorized)
+	reload(http.MethodPost, "Bearer wrong", http.StatusUnauthorized)
+	reload(http.MethodPost, "Bearer scrape", http.StatusOK)
+}
+
+func TestReloadSignal(t *testing.T) {
+	dir := t.TempDir()
+	configFile := writeConfig(t, dir, "", "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	// The file watcher only reloads the configuration a second after it was
+	// written, so the new prefix is only seen this early after SIGHUP
+	cfg := "port: 9347\nmetrics_prefix: signaled\nhosts:\n  default:\n    username: dummy\n    password: dummy\n"
+	if err := os.WriteFile(configFile, []byte(cfg), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	exporter.Signal(syscall.SIGHUP)
+	time.Sleep(300 * time.Millisecond)
+
+	resp, err := get("http://localhost:9347/exporter_metrics")
+	if err != nil {
+		t.Fatalf("Failed to get exporter metrics: %v", err)
+	}
+	if !strings.Contains(resp, "signaled_gpu_exporter_config_last_reload_successful 1") {
+		t.Fatalf("Configuration was not reloaded on SIGHUP.\nGot:\n%s", resp)
+	}
+}
+
+func TestReloadConfigMap(t *testing.T) {
+	server := NewTestServer(t, "dell")
+	defer server.Close()
+
+	// Lay out the configuration like a mounted ConfigMap, whose files link to
+	// a data directory that is replaced by swapping the ..data link
+	dir := t.TempDir()
+	writeData := func(name string, prefix string) {
+		data := filepath.Join(dir, name)
+		if err := os.Mkdir(data, 0755); err != nil {
+			t.Fatalf("Failed to create data directory: %v", err)
+		}
+		cfg := fmt.Sprintf("port: 9347\nmetrics_prefix: %s\nhosts:\n  default:\n    username: dummy\n    password: dummy\n", prefix)
+		if err := os.WriteFile(filepath.Join(data, "config.yml"), []byte(cfg), 0644); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		if err := os.Symlink(name, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatalf("Failed to link data directory: %v", err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatalf("Failed to swap data directory: %v", err)
+		}
+	}
+
+	writeData("..1", "oob")
+	configFile := filepath.Join(dir, "config.yml")
+	if err := os.Symlink(filepath.Join("..data", "config.yml"), configFile); err != nil {
+		t.Fatalf("Failed to link config file: %v", err)
+	}
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	writeData("..2", "swapped")
+	time.Sleep(1500 * time.Millisecond)
+
+	if !strings.Contains(getMetrics(t, server), "swapped_gpu_num_gpus") {
+		t.Fatalf("Configuration was not reloaded after swapping the data directory")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	var deleted atomic.Bool
+	server := newSessionServer(&deleted)
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+
+	resp, err := get("http://localhost:9347/metrics?target=" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	if !strings.Contains(resp, "oob_gpu_exporter_up 1") {
+		t.Fatalf("Scrape was not successful.\nGot:\n%s", resp)
+	}
+
+	exporter.Stop()
+
+	if !deleted.Load() {
+		t.Fatalf("Session was not deleted on shutdown")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	var deleted atomic.Bool
+	var connections atomic.Int32
+	sessionServer := httptest.NewUnstartedServer(newSessionHandler(&deleted))
+	sessionServer.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			connections.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			connections.Add(-1)
+		}
+	}
+	sessionServer.StartTLS()
+	defer sessionServer.Close()
+
 	server := NewTestServer(t, "dell")
 	defer server.Close()
 
-    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	configFile := writeConfig(t, t.TempDir(), "max_collectors: 1", "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
 	defer exporter.Stop()
 
-    resp := getMetrics(t, server)
+	_, err := get("http://localhost:9347/metrics?target=" + sessionServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
 
-    assert_equal(t, "dell_expected.txt", resp)
+	// The collector of the first target is evicted to make room for the second
+	getMetrics(t, server)
+
+	if !eventually(deleted.Load) {
+		t.Fatalf("Session was not deleted on eviction")
+	}
+	if !eventually(func() bool { return connections.Load() == 0 }) {
+		t.Fatalf("%d connections to the evicted target are still open", connections.Load())
+	}
+
+	resp, err := get("http://localhost:9347/exporter_metrics")
+	if err != nil {
+		t.Fatalf("Failed to get exporter metrics: %v", err)
+	}
+	for _, expected := range []string{"oob_gpu_exporter_collectors 1\n", "oob_gpu_exporter_redfish_sessions 0\n"} {
+		if !strings.Contains(resp, expected) {
+			t.Fatalf("Exporter metrics do not contain %q.\nGot:\n%s", expected, resp)
+		}
+	}
 }
 
-func TestSYS421GETNRT(t *testing.T) {
-	server := NewTestServer(t, "SYS-421GE-TNRT")
+func TestEvictionPolled(t *testing.T) {
+	var deleted atomic.Bool
+	sessionServer := newSessionServer(&deleted)
+	defer sessionServer.Close()
+
+	server := NewTestServer(t, "dell")
 	defer server.Close()
 
-    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	configFile := writeConfig(t, t.TempDir(), "max_collectors: 1", "")
+	polled := "  " + sessionServer.Listener.Addr().String() + ":\n    username: dummy\n    password: dummy\n    poll_interval: 60\n"
+	cfg, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatalf("Failed to read config file: %v", err)
+	}
+	if err := os.WriteFile(configFile, append(cfg, polled...), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	exporter := NewOOBGPUExporter(t, configFile)
 	defer exporter.Stop()
 
-    resp := getMetrics(t, server)
+	// The collector of the polled target is kept although the second target
+	// exceeds the maximum number of collectors
+	for i := 0; i < 20; i++ {
+		resp, _ := get("http://localhost:9347/metrics?target=" + sessionServer.Listener.Addr().String())
+		if strings.Contains(resp, "oob_gpu_exporter_up 1") {
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	getMetrics(t, server)
+	time.Sleep(100 * time.Millisecond)
 
-    assert_equal(t, "SYS-421GE-TNRT_expected.txt", resp)
+	if deleted.Load() {
+		t.Fatalf("Session of polled target was deleted on eviction")
+	}
 }
 
-func TestAS4124GONARTPlus(t *testing.T) {
-	server := NewTestServer(t, "AS -4124GO-NART+")
+func TestExpandRejected(t *testing.T) {
+	// The service announces $expand but rejects expanded requests
+	var rejected atomic.Int32
+	files := fileHandler(filepath.Join("testdata", "dell"))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("$expand") != "" {
+			rejected.Add(1)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		files(w, r)
+	}))
 	defer server.Close()
 
-    exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
 	defer exporter.Stop()
 
-    resp := getMetrics(t, server)
+	resp, err := get("http://localhost:9347/metrics?target=" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	if rejected.Load() == 0 {
+		t.Fatalf("No expanded request was sent")
+	}
 
-    assert_equal(t, "AS -4124GO-NART+_expected.txt", resp)
+	// The members are fetched one by one, which takes more requests
+	requests := regexp.MustCompile(`oob_gpu_exporter_scrape_requests [0-9]+`)
+	assert_equal(t, "dell_expected.txt", requests.ReplaceAllString(resp, "oob_gpu_exporter_scrape_requests 25"))
+}
+
+func TestPolling(t *testing.T) {
+	var polls atomic.Int32
+	server := newPollServer(&polls, nil, nil)
+	defer server.Close()
+
+	target := server.Listener.Addr().String()
+	dir := t.TempDir()
+	configFile := writePollConfig(t, dir, target, "reload_token: secret", 1)
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	// The reload endpoint only answers once the pollers were updated
+	reload := func() {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:9347/-/reload", nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to request reload: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Reload returned %d", resp.StatusCode)
+		}
+	}
+
+	// The host is polled right away and then every poll_interval
+	waitForPoll(t, target)
+	start := time.Now()
+	if !eventually(func() bool { return polls.Load() >= 3 }) {
+		t.Fatalf("Host was polled %d times, expected 3", polls.Load())
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("Host was polled 3 times within %v, expected 2 intervals", elapsed)
+	}
+
+	// Scrapes are answered from the snapshot without contacting the host
+	writePollConfig(t, dir, target, "reload_token: secret", 60)
+	reload()
+	waitForPoll(t, target)
+	before := polls.Load()
+	for i := 0; i < 3; i++ {
+		resp, err := get("http://localhost:9347/metrics?target=" + target)
+		if err != nil || !strings.Contains(resp, "oob_gpu_num_gpus") {
+			t.Fatalf("Failed to get snapshot: %v\nGot:\n%s", err, resp)
+		}
+	}
+	if n := polls.Load(); n != before {
+		t.Fatalf("Scrapes of polled host contacted it %d times", n-before)
+	}
+
+	// Without poll_interval the host is scraped directly after a reload
+	writePollConfig(t, dir, target, "reload_token: secret", 0)
+	reload()
+	before = polls.Load()
+	if _, err := get("http://localhost:9347/metrics?target=" + target); err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	if n := polls.Load(); n != before+1 {
+		t.Fatalf("Scrape after the poller was stopped contacted the host %d times, expected once", n-before)
+	}
+}
+
+func TestPollingStaleness(t *testing.T) {
+	// All polls after the first one fail
+	var polls atomic.Int32
+	var fail atomic.Bool
+	server := newPollServer(&polls, &fail, nil)
+	defer server.Close()
+
+	target := server.Listener.Addr().String()
+	configFile := writePollConfig(t, t.TempDir(), target, "max_staleness: 2", 1)
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	waitForPoll(t, target)
+	start := time.Now()
+	fail.Store(true)
+
+	// The last good snapshot is served until it is older than max_staleness
+	failed := polls.Load()
+	if !eventually(func() bool { return polls.Load() > failed }) {
+		t.Fatalf("Host was not polled again")
+	}
+	resp, err := get("http://localhost:9347/metrics?target=" + target)
+	if err != nil || !strings.Contains(resp, "oob_gpu_exporter_up 1\n") {
+		t.Fatalf("Previous snapshot was not kept: %v\nGot:\n%s", err, resp)
+	}
+
+	if !eventually(func() bool {
+		resp, err = get("http://localhost:9347/metrics?target=" + target)
+		return err == nil && strings.Contains(resp, "oob_gpu_exporter_up 0\n") && !strings.Contains(resp, "oob_gpu_num_gpus")
+	}) {
+		t.Fatalf("Stale snapshot was not dropped: %v\nGot:\n%s", err, resp)
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Fatalf("Snapshot was dropped after %v, before max_staleness", elapsed)
+	}
+}
+
+func TestPollingShutdown(t *testing.T) {
+	// The first poll hangs until it is cancelled
+	var polls atomic.Int32
+	var deleted atomic.Bool
+	server := newPollServer(&polls, nil, &deleted)
+	defer server.Close()
+
+	target := server.Listener.Addr().String()
+	configFile := writePollConfig(t, t.TempDir(), target, "", 60)
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	for polls.Load() < 1 {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// The ongoing poll is cancelled and waited for before the sessions are
+	// deleted, instead of delaying the shutdown or leaking a session
+	start := time.Now()
+	exporter.Stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Shutdown took %v with an ongoing poll", elapsed)
+	}
+	if !deleted.Load() {
+		t.Fatalf("Session was not deleted on shutdown")
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	// The memory metrics of the GPUs are only returned after the scrape timeout
+	files := fileHandler(filepath.Join("testdata", "dell"))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/MemoryMetrics") {
+			select {
+			case <-time.After(10 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		files(w, r)
+	}))
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:9347/metrics?target="+server.Listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "2")
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Scrape took %v, longer than the scrape timeout", elapsed)
+	}
+
+	// The metrics collected before the timeout are returned
+	for _, expected := range []string{"oob_gpu_exporter_scrape_timed_out 1\n", "oob_gpu_exporter_up 0\n", "oob_gpu_num_gpus{"} {
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("Metrics do not contain %q.\nGot:\n%s", expected, body)
+		}
+	}
+}
+
+func TestScrapeTimeoutDiscovery(t *testing.T) {
+	// A discovery that timed out is repeated on the next scrape
+	files := fileHandler(filepath.Join("testdata", "HGX-H100-host"))
+	var hanging atomic.Bool
+	hanging.Store(true)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redfish/v1/Systems/HGX_Baseboard_0" && hanging.Load() {
+			<-r.Context().Done()
+			return
+		}
+		files(w, r)
+	}))
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+	url := "http://localhost:9347/metrics?target=" + server.Listener.Addr().String()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if !strings.Contains(string(body), "oob_gpu_exporter_scrape_timed_out 1\n") {
+		t.Fatalf("Scrape did not time out.\nGot:\n%s", body)
+	}
+
+	hanging.Store(false)
+	metrics, err := get(url)
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+
+	for _, expected := range []string{"oob_gpu_exporter_up 1\n", `system="HGX_Baseboard_0"`} {
+		if !strings.Contains(metrics, expected) {
+			t.Fatalf("Metrics do not contain %q.\nGot:\n%s", expected, metrics)
+		}
+	}
+}
+
+func TestCollectionErrors(t *testing.T) {
+	// The metrics of one GPU fail while those of the other GPUs are available
+	files := fileHandler(filepath.Join("testdata", "dell"))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/Video.Slot.21-1/ProcessorMetrics") {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		files(w, r)
+	}))
+	defer server.Close()
+
+	exporter := NewOOBGPUExporter(t, "testdata/config.yml")
+	defer exporter.Stop()
+
+	resp, err := get("http://localhost:9347/metrics?target=" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+
+	labels := `chassis="System.Embedded.1",id="%s",system="System.Embedded.1"`
+	for _, expected := range []string{
+		"oob_gpu_exporter_up 1\n",
+		`oob_gpu_collection_errors{chassis="System.Embedded.1",id="Video.Slot.21-1",resource="processor_metrics",system="System.Embedded.1"} 1`,
+		fmt.Sprintf("oob_gpu_memory_operating_speed_mhz{"+labels+"} 3199", "Video.Slot.21-1"),
+		fmt.Sprintf("oob_gpu_operating_speed_mhz{"+labels+"}", "Video.Slot.28-1"),
+	} {
+		if !strings.Contains(resp, expected) {
+			t.Fatalf("Metrics do not contain %q.\nGot:\n%s", expected, resp)
+		}
+	}
+
+	if strings.Contains(resp, fmt.Sprintf("oob_gpu_operating_speed_mhz{"+labels+"}", "Video.Slot.21-1")) {
+		t.Fatalf("Metrics contain the operating speed of the failed GPU.\nGot:\n%s", resp)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	// The metrics of one GPU are only returned on the second attempt, after
+	// the wait requested by the first response
+	var attempts atomic.Int64
+	var first, retried atomic.Int64
+	files := fileHandler(filepath.Join("testdata", "dell"))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/Video.Slot.21-1/ProcessorMetrics") {
+			if attempts.Add(1) == 1 {
+				first.Store(time.Now().UnixNano())
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			retried.Store(time.Now().UnixNano() - first.Load())
+		}
+		files(w, r)
+	}))
+	defer server.Close()
+
+	configFile := writeConfig(t, t.TempDir(), "retries: 2\nretry_backoff: 0", "")
+
+	exporter := NewOOBGPUExporter(t, configFile)
+	defer exporter.Stop()
+
+	target := server.Listener.Addr().String()
+	resp, err := get("http://localhost:9347/metrics?target=" + target)
+	if err != nil {
+		t.Fatalf("Failed to get metrics: %v", err)
+	}
+
+	if strings.Contains(resp, "oob_gpu_collection_errors") {
+		t.Fatalf("Metrics contain collection errors.\nGot:\n%s", resp)
+	}
+	if attempts.Load() != 2 {
+		t.Fatalf("Metrics of the GPU were requested %d times, expected 2", attempts.Load())
+	}
+	if time.Duration(retried.Load()) < time.Second {
+		t.Fatalf("Retry after %v did not honor Retry-After", time.Duration(retried.Load()))
+	}
+
+	resp, err = get("http://localhost:9347/exporter_metrics")
+	if err != nil {
+		t.Fatalf("Failed to get exporter metrics: %v", err)
+	}
+	expected := fmt.Sprintf("oob_gpu_exporter_redfish_retries_total{target=%q} 1\n", target)
+	if !strings.Contains(resp, expected) {
+		t.Fatalf("Exporter metrics do not contain %q.\nGot:\n%s", expected, resp)
+	}
 }
 
 // TestServer is a simple HTTPS server that serves files from a specified directory.
@@ -96,6 +1440,14 @@ func fileHandler(baseDir string) http.HandlerFunc {
 			return
 		}
 
+		if r.URL.Query().Get("$expand") != "" {
+			data, err = expandMembers(baseDir, data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_, err = w.Write(data)
@@ -105,6 +1457,194 @@ func fileHandler(baseDir string) http.HandlerFunc {
 	}
 }
 
+// expandMembers replaces the links in the members of a collection with the
+// resources they refer to, like a Redfish service does for $expand.
+func expandMembers(baseDir string, data []byte) ([]byte, error) {
+	var collection map[string]any
+	err := json.Unmarshal(data, &collection)
+	if err != nil {
+		return nil, err
+	}
+
+	members, ok := collection["Members"].([]any)
+	if !ok {
+		return data, nil
+	}
+
+	for i, m := range members {
+		link, ok := m.(map[string]any)["@odata.id"].(string)
+		if !ok {
+			continue
+		}
+
+		memberData, err := os.ReadFile(filepath.Join(baseDir, filepath.Clean(link), "index.json"))
+		if err != nil {
+			continue
+		}
+
+		var member any
+		err = json.Unmarshal(memberData, &member)
+		if err != nil {
+			return nil, err
+		}
+		members[i] = member
+	}
+
+	return json.Marshal(collection)
+}
+
+// newSessionServer starts a server with a handler of newSessionHandler.
+func newSessionServer(deleted *atomic.Bool) *httptest.Server {
+	return httptest.NewTLSServer(newSessionHandler(deleted))
+}
+
+// newSessionHandler serves the Dell test data like a TestServer, but also
+// creates sessions and records their deletion.
+func newSessionHandler(deleted *atomic.Bool) http.Handler {
+	sessions := "/redfish/v1/SessionService/Sessions"
+	files := fileHandler(filepath.Join("testdata", "dell"))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == sessions:
+			w.Header().Set("X-Auth-Token", "token")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"@odata.id": "%s/1"}`, sessions)
+		case r.URL.Path == sessions+"/1":
+			if r.Method == http.MethodDelete {
+				deleted.Store(true)
+			}
+			fmt.Fprint(w, `{}`)
+		default:
+			files(w, r)
+		}
+	})
+}
+
+// newPollServer serves the Dell test data and counts the polls by the GPU
+// sensors requested once per collection. All requests fail while fail is set,
+// each of which is counted as a poll, and polls hang until they are cancelled if deleted is given, which records
+// the deletion of the session.
+func newPollServer(polls *atomic.Int32, fail *atomic.Bool, deleted *atomic.Bool) *httptest.Server {
+	sessions := "/redfish/v1/SessionService/Sessions"
+	files := fileHandler(filepath.Join("testdata", "dell"))
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case deleted != nil && r.Method == http.MethodPost && r.URL.Path == sessions:
+			w.Header().Set("X-Auth-Token", "token")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"@odata.id": "%s/1"}`, sessions)
+			return
+		case deleted != nil && r.URL.Path == sessions+"/1":
+			if r.Method == http.MethodDelete {
+				deleted.Store(true)
+			}
+			fmt.Fprint(w, `{}`)
+			return
+		case fail != nil && fail.Load():
+			polls.Add(1)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/DellGPUSensors") {
+			polls.Add(1)
+			if deleted != nil {
+				<-r.Context().Done()
+				return
+			}
+		}
+		files(w, r)
+	}))
+}
+
+// writePollConfig writes a configuration that polls the target with the
+// given interval, or not at all with an interval of 0.
+func writePollConfig(t *testing.T, dir string, target string, rootOptions string, interval int) string {
+	configFile := writeConfig(t, dir, rootOptions, "")
+	if interval == 0 {
+		return configFile
+	}
+
+	host := fmt.Sprintf("  %q:\n    username: dummy\n    password: dummy\n    poll_interval: %d\n", target, interval)
+	cfg, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatalf("Failed to read config file: %v", err)
+	}
+	if err := os.WriteFile(configFile, append(cfg, host...), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	return configFile
+}
+
+// eventually polls the condition until it is met or 5 seconds have passed and
+// returns whether it was met.
+func eventually(condition func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// waitForPoll waits until the snapshot of the polled target is available.
+func waitForPoll(t *testing.T, target string) {
+	t.Helper()
+	for i := 0; i < 40; i++ {
+		resp, _ := get("http://localhost:9347/metrics?target=" + target)
+		if strings.Contains(resp, "oob_gpu_exporter_up 1\n") {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("No snapshot of polled host %s", target)
+}
+
+// newCertificate creates a certificate signed by the parent, or a self-signed
+// one without parent, and writes it with its key to dir.
+func newCertificate(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0644); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	return cert, key
+}
+
 // OOBGPUExporter manages the lifecycle of the oob_gpu_exporter process for testing.
 
 type OOBGPUExporter struct {
@@ -137,7 +1677,8 @@ func NewOOBGPUExporter(t *testing.T, configPath string) *OOBGPUExporter {
 func waitFor(endpoint string, secs int) bool {
 	for i := 0; i < secs*2; i++ {
 		resp, err := http.Get(endpoint)
-		if err == nil && resp.StatusCode == http.StatusOK {
+		// A server with TLS answers plain HTTP requests with 400
+		if err == nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusBadRequest) {
 			fmt.Println("HTTP server is up and running!")
 			err = resp.Body.Close()
             if err != nil {
@@ -161,6 +1702,35 @@ func (oobGPUExporter *OOBGPUExporter) Stop() {
     oobGPUExporter.cmd.Wait()
 }
 
+// Signal sends the signal to the exporter, which runs as child of go run
+// started by any of its threads.
+func (oobGPUExporter *OOBGPUExporter) Signal(sig syscall.Signal) {
+	tasks, err := filepath.Glob(fmt.Sprintf("/proc/%d/task/*/children", oobGPUExporter.cmd.Process.Pid))
+	if err != nil || len(tasks) == 0 {
+		oobGPUExporter.t.Fatalf("Failed to find exporter process: %v", err)
+	}
+	children := []string{}
+	for _, task := range tasks {
+		content, err := os.ReadFile(task)
+		if err != nil {
+			oobGPUExporter.t.Fatalf("Failed to find exporter process: %v", err)
+		}
+		children = append(children, strings.Fields(string(content))...)
+	}
+	if len(children) == 0 {
+		oobGPUExporter.t.Fatalf("Failed to find exporter process")
+	}
+	for _, child := range children {
+		childPid, err := strconv.Atoi(child)
+		if err != nil {
+			oobGPUExporter.t.Fatalf("Failed to parse exporter pid %q: %v", child, err)
+		}
+		if err := syscall.Kill(childPid, sig); err != nil {
+			oobGPUExporter.t.Fatalf("Failed to signal exporter: %v", err)
+		}
+	}
+}
+
 // Convenience functions
 
 func getMetrics(t *testing.T, server *TestServer) string {
@@ -177,13 +1747,30 @@ func assert_equal(t *testing.T, expected string, resp string) {
 		t.Fatalf("Failed to read expected response: %v", err)
 	}
 
-	// Compare the metrics excluding go build version
-	re := regexp.MustCompile(`"go[0-9]+.[0-9]+.[0-9]+`)
+	// Compare the metrics excluding go build version, timestamps and durations
+	re := regexp.MustCompile(`"go[0-9]+.[0-9]+.[0-9]+|_(timestamp|duration)_seconds({[^}]*})? [0-9][0-9.e+-]*`)
 	if re.ReplaceAllString(resp, "") != re.ReplaceAllString(expectedContent, "") {
 		t.Fatalf("Metrics do not match expected content.\nGot:\n%s\nExpected:\n%s", resp, expectedContent)
 	}
 }
 
+// writeConfig writes a configuration file with the given root options and
+// options of the default host to dir.
+func writeConfig(t *testing.T, dir string, rootOptions string, hostOptions string) string {
+	cfg := "port: 9347\nmetrics_prefix: oob\n" + rootOptions + "\n"
+	cfg += "hosts:\n  default:\n    username: dummy\n    password: dummy\n"
+	for _, option := range strings.Split(hostOptions, "\n") {
+		cfg += "    " + option + "\n"
+	}
+
+	configFile := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(configFile, []byte(cfg), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	return configFile
+}
+
 func readTestFile(path ...string) (string, error) {
 	content := filepath.Join(path...)
 
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+)
+
+// TLS configuration of the server including its certificate, which is
+// replaced on reload without closing the listener or existing connections
+var serverTLSConfig atomic.Pointer[tls.Config]
+
+// Loads the TLS configuration and certificate of the server if TLS is enabled
+func loadServerTLS(cfg *config.RootConfig) error {
+	if !cfg.TLS.Enabled {
+		return nil
+	}
+
+	tlsConfig, err := cfg.TLS.ServerConfig()
+	if err != nil {
+		return fmt.Errorf("load server TLS configuration: %v", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load server certificate: %v", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	serverTLSConfig.Store(tlsConfig)
+	return nil
+}
+
+func getServerTLSConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return serverTLSConfig.Load(), nil
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+	"github.com/firmus-public/oob_gpu_exporter/internal/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Fingerprints trusted on first use, which are kept in the state file
+var fingerprintsMutex sync.Mutex
+var fingerprints map[string]string
+var fingerprintsFile string
+
+// Returns the SHA-256 fingerprint of a DER encoded certificate as hex string
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns a function verifying the certificate of the host against its pinned
+// or trusted on first use fingerprint, which also records the expiry of the
+// certificate. The fingerprint replaces the verification against a CA, so
+// that self-signed certificates can be used.
+func (r *Redfish) verifyConnection(h *config.HostConfig) func(tls.ConnectionState) error {
+	pinned := config.NormalizeFingerprint(h.Fingerprint)
+	tofu := h.TrustOnFirstUse && pinned == ""
+
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("no certificate presented by %s", h.Hostname)
+		}
+		leaf := cs.PeerCertificates[0]
+		r.certExpiry.Store(leaf.NotAfter.Unix())
+
+		fingerprint := certFingerprint(leaf.Raw)
+		if tofu {
+			return trustOnFirstUse(h.Hostname, fingerprint)
+		}
+
+		if pinned != "" && fingerprint != pinned {
+			return fmt.Errorf("certificate fingerprint %s of %s does not match pinned fingerprint", fingerprint, h.Hostname)
+		}
+
+		return nil
+	}
+}
+
+// Compares the fingerprint of the host with the one stored in the state file,
+// if there is none yet the fingerprint is stored
+func trustOnFirstUse(hostname string, fingerprint string) error {
+	fingerprintsMutex.Lock()
+	defer fingerprintsMutex.Unlock()
+
+	filename := config.Current().Fingerprints
+
+	// The state file is read again if it was changed by a reload
+	if fingerprints == nil || fingerprintsFile != filename {
+		fingerprints = map[string]string{}
+		fingerprintsFile = filename
+
+		data, err := os.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("read fingerprints file: %v", err)
+		}
+		err = yaml.Unmarshal(data, &fingerprints)
+		if err != nil {
+			return fmt.Errorf("parse fingerprints file: %v", err)
+		}
+	}
+
+	trusted, ok := fingerprints[hostname]
+	if ok {
+		if trusted != fingerprint {
+			return fmt.Errorf("certificate fingerprint %s of %s does not match fingerprint %s trusted on first use", fingerprint, hostname, trusted)
+		}
+		return nil
+	}
+
+	fingerprints[hostname] = fingerprint
+	err := saveFingerprints(filename)
+	if err != nil {
+		delete(fingerprints, hostname)
+		return err
+	}
+
+	log.Info("Trusting certificate with fingerprint %s of %s on first use", fingerprint, hostname)
+	return nil
+}
+
+// Writes the fingerprints to a temporary file first, so that the state file
+// is never left partially written
+func saveFingerprints(filename string) error {
+	data, err := yaml.Marshal(fingerprints)
+	if err != nil {
+		return fmt.Errorf("encode fingerprints: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("create fingerprints file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("write fingerprints file: %v", err)
+	}
+
+	err = os.Rename(tmp.Name(), filename)
+	if err != nil {
+		return fmt.Errorf("write fingerprints file: %v", err)
+	}
+
+	return nil
+}
+
+// Returns the time the certificate of the host expires, which is only known
+// once a TLS connection to the host was made
+func (r *Redfish) CertExpiry() (time.Time, bool) {
+	expiry := r.certExpiry.Load()
+	return time.Unix(expiry, 0), expiry != 0
+}
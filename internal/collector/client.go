@@ -1,10 +1,14 @@
 package collector
 
 import (
+	"context"
 	"fmt"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/firmus-public/oob_gpu_exporter/internal/config"
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,12 +28,30 @@ const (
 
 type Client struct {
 	redfish     *Redfish
-	vendor      int
-	systemPath  string
+	systems     []*systemEndpoints
+	concurrency int
+	expand      string
+	metrics     config.MetricsConfig
+
+	// Paths of the systems that could not be discovered
+	failedSystems []string
+}
+
+// Endpoints of a computer system and of the chassis paired with it
+type systemEndpoints struct {
+	id          string
+	path        string
 	procPath    string
+	vendor      int
+	hgx         bool
+	chassisId   string
 	chassisPath string
 	devicesPath string
 	thermalPath string
+	sensorsPath string
+
+	// NVIDIA HGX baseboard
+	nvswitchPaths []string
 }
 
 type GPUInfo struct {
@@ -42,152 +64,323 @@ type GPUInfo struct {
 	Slot         int
 }
 
-func NewClient(h *config.HostConfig) *Client {
+func NewClient(h *config.HostConfig) (*Client, error) {
+	redfish, err := NewRedfish(h)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &Client{
-		redfish: NewRedfish(
-			h.Scheme,
-			h.Hostname,
-			h.Username,
-			h.Password,
-		),
+		redfish:     redfish,
+		concurrency: int(h.Concurrency),
+		metrics:     h.Metrics,
 	}
 
-	client.redfish.CreateSession()
-	ok := client.findAllEndpoints()
-	if !ok {
-		client.redfish.DeleteSession()
-		return nil
+	if client.concurrency == 0 {
+		client.concurrency = int(config.Current().Concurrency)
 	}
 
-	return client
+	if client.metrics == nil {
+		client.metrics = config.Current().Metrics
+	}
+
+	return client, nil
+}
+
+// Returns whether the systems of the host have been discovered, which is
+// attempted again as long as any of them could not be discovered
+func (client *Client) Discovered() bool {
+	return len(client.systems) > 0 && len(client.failedSystems) == 0
 }
 
-func (client *Client) findAllEndpoints() bool {
+// Discovers the systems of the host, the session is deleted again if the host
+// cannot be discovered so that it is created anew on the next attempt. If the
+// scrape timed out the session is kept and refreshed on the next attempt.
+func (client *Client) Discover(ctx context.Context) bool {
+	ok := client.findAllEndpoints(ctx)
+	if !ok && ctx.Err() == nil {
+		client.redfish.DeleteSession(ctx)
+	}
+	return ok
+}
+
+func (client *Client) findAllEndpoints(ctx context.Context) bool {
 	var root V1Response
 	var group GroupResponse
-	var system SystemResponse
-	var chassis ChassisResponse
 	var ok bool
 
+	// Start over if a previous attempt failed halfway
+	client.systems = nil
+	client.failedSystems = nil
+	client.expand = ""
+
 	// Root
-	ok = client.redfish.Get(redfishRootPath, &root)
+	ok = client.redfish.Get(ctx, redfishRootPath, &root)
 	if !ok {
 		return false
 	}
 
+	// Expand the members of collections when supported, but not their links
+	e := root.ProtocolFeaturesSupported.ExpandQuery
+	if e != nil && (e.NoLinks || e.ExpandAll) {
+		if e.NoLinks {
+			client.expand = "$expand=."
+		} else {
+			client.expand = "$expand=*"
+		}
+		if e.Levels {
+			client.expand += "($levels=1)"
+		}
+	}
+
 	// Chassis
-	ok = client.redfish.Get(root.Chassis.OdataId, &group)
+	ok = client.redfish.Get(ctx, root.Chassis.OdataId, &group)
 	if !ok {
 		return false
 	}
 
-	client.chassisPath = group.Members[0].OdataId
-
-	ok = client.redfish.Get(client.chassisPath, &chassis)
-	if !ok {
+	chassisPaths := group.Members.GetLinks()
+	if len(chassisPaths) == 0 {
 		return false
 	}
 
-	// System
-	ok = client.redfish.Get(root.Systems.OdataId, &group)
+	// Systems
+	ok = client.redfish.Get(ctx, root.Systems.OdataId, &group)
 	if !ok {
 		return false
 	}
 
-	client.systemPath = group.Members[0].OdataId
+	chassisCache := map[string]*ChassisResponse{}
+	for i, c := range group.Members.GetLinks() {
+		system := SystemResponse{}
+		ok = client.redfish.Get(ctx, c, &system)
+		if !ok {
+			client.failedSystems = append(client.failedSystems, c)
+			continue
+		}
 
-	ok = client.redfish.Get(client.systemPath, &system)
-	if !ok {
+		// Pair the system with the chassis it links to, falling back to the
+		// chassis at the same position and then to the first chassis
+		chassisPath := chassisPaths[0]
+		if links := system.Links.Chassis.GetLinks(); len(links) > 0 {
+			chassisPath = links[0]
+		} else if i < len(chassisPaths) {
+			chassisPath = chassisPaths[i]
+		}
+
+		chassis, found := chassisCache[chassisPath]
+		if !found {
+			chassis = &ChassisResponse{}
+			ok = client.redfish.Get(ctx, chassisPath, chassis)
+			if !ok {
+				client.failedSystems = append(client.failedSystems, c)
+				continue
+			}
+			chassisCache[chassisPath] = chassis
+		}
+
+		sys := &systemEndpoints{
+			id:          system.Id,
+			path:        c,
+			procPath:    system.Processors.OdataId,
+			vendor:      findVendor(system.Manufacturer),
+			hgx:         strings.HasPrefix(path.Base(c), "HGX_Baseboard_"),
+			chassisId:   chassis.Id,
+			chassisPath: chassisPath,
+			devicesPath: chassis.PCIeDevices.OdataId,
+			thermalPath: chassis.Thermal.OdataId,
+			sensorsPath: chassis.Sensors.OdataId,
+		}
+		if sys.id == "" {
+			sys.id = path.Base(c)
+		}
+		if sys.chassisId == "" {
+			sys.chassisId = path.Base(chassisPath)
+		}
+
+		client.systems = append(client.systems, sys)
+	}
+
+	client.findNVSwitches(ctx, chassisPaths)
+
+	// The endpoints found before the scrape timed out are incomplete, they are
+	// discovered again on the next scrape
+	if ctx.Err() != nil {
+		client.systems = nil
 		return false
 	}
 
-	client.procPath = system.Processors.OdataId
-	client.devicesPath = chassis.PCIeDevices.OdataId
-	client.thermalPath = chassis.Thermal.OdataId
+	return len(client.systems) > 0
+}
+
+// Assigns the NVSwitches to the HGX baseboard whose chassis contains them,
+// falling back to the first baseboard if that is unknown
+func (client *Client) findNVSwitches(ctx context.Context, chassisPaths []string) {
+	var baseboards []*systemEndpoints
+	for _, sys := range client.systems {
+		if sys.hgx {
+			baseboards = append(baseboards, sys)
+		}
+	}
+	if len(baseboards) == 0 {
+		return
+	}
+
+	for _, c := range chassisPaths {
+		if !strings.HasPrefix(path.Base(c), "HGX_NVSwitch_") {
+			continue
+		}
+
+		baseboard := baseboards[0]
+		nvswitch := ChassisResponse{}
+		if client.redfish.Get(ctx, c, &nvswitch) {
+			for _, sys := range baseboards {
+				if sys.chassisPath == nvswitch.Links.ContainedBy.OdataId {
+					baseboard = sys
+				}
+			}
+		}
+
+		baseboard.nvswitchPaths = append(baseboard.nvswitchPaths, c)
+	}
+}
 
-	// Vendor
-	m := strings.ToLower(system.Manufacturer)
+func findVendor(manufacturer string) int {
+	m := strings.ToLower(manufacturer)
 	if strings.Contains(m, "dell") || strings.Contains(m, "sustainable") {
-		client.vendor = DELL
+		return DELL
 	} else if strings.Contains(m, "hpe") {
-		client.vendor = HPE
+		return HPE
 	} else if strings.Contains(m, "lenovo") {
-		client.vendor = LENOVO
+		return LENOVO
 	} else if strings.Contains(m, "inspur") {
-		client.vendor = INSPUR
+		return INSPUR
 	} else if strings.Contains(m, "h3c") {
-		client.vendor = H3C
+		return H3C
 	} else if strings.Contains(m, "inventec") {
-		client.vendor = INVENTEC
+		return INVENTEC
 	} else if strings.Contains(m, "fujitsu") {
-		client.vendor = FUJITSU
+		return FUJITSU
 	} else if strings.Contains(m, "supermicro") {
-		client.vendor = SUPERMICRO
+		return SUPERMICRO
 	}
+	return UNKNOWN
+}
 
-	return true
+// Returns whether any of the given metric groups is selected for the host,
+// resources are only fetched if a metric derived from them is selected
+func (client *Client) enabled(groups ...string) bool {
+	return client.metrics.Enabled(groups...)
+}
+
+// Collect the GPUs of every system, returns false if any of them failed or
+// could not be discovered. A failed or undiscovered system is reported as
+// collection error of the system, the resources
+// of single GPUs that could not be fetched do not fail the system and are
+// reported as collection errors instead.
+func (client *Client) RefreshGPUs(ctx context.Context, mc *Collector, ch chan<- prometheus.Metric) bool {
+	disabled := mc.disabledMetrics(client.metrics)
+
+	ok := true
+	for _, sys := range client.systems {
+		s := &scope{
+			ch:       ch,
+			system:   sys.id,
+			chassis:  sys.chassisId,
+			disabled: disabled,
+		}
+		if !client.refreshSystemGPUs(ctx, mc, s, sys) {
+			s.fail(sys.id, "system")
+			ok = false
+		}
+		mc.NewCollectionErrors(s)
+	}
+
+	// The GPUs of systems that could not be discovered are missing
+	for _, c := range client.failedSystems {
+		s := &scope{
+			ch:       ch,
+			system:   path.Base(c),
+			disabled: disabled,
+		}
+		s.fail(path.Base(c), "system")
+		mc.NewCollectionErrors(s)
+		ok = false
+	}
+	return ok
 }
 
-func (client *Client) RefreshGPUs(mc *Collector, ch chan<- prometheus.Metric) bool {
-	switch client.vendor {
+func (client *Client) refreshSystemGPUs(ctx context.Context, mc *Collector, s *scope, sys *systemEndpoints) bool {
+	// The GPUs of an HGX baseboard are managed by its own controller
+	if sys.hgx {
+		return client.refreshHgxGPUs(ctx, mc, s, sys)
+	}
+
+	switch sys.vendor {
 	case DELL:
-		return client.refreshDellGPUs(mc, ch)
+		return client.refreshDellGPUs(ctx, mc, s, sys)
+	case HPE:
+		return client.refreshHpeGPUs(ctx, mc, s, sys)
+	case LENOVO:
+		return client.refreshLenovoGPUs(ctx, mc, s, sys)
 	case SUPERMICRO:
-		return client.refreshSupermicroGPUs(mc, ch)
+		return client.refreshSupermicroGPUs(ctx, mc, s, sys)
 	default:
-		return false
+		// UNKNOWN, INSPUR, H3C, INVENTEC and FUJITSU
+		return client.refreshGenericGPUs(ctx, mc, s, sys)
 	}
 }
 
-func (client *Client) refreshDellGPUs(mc *Collector, ch chan<- prometheus.Metric) bool {
-	group := GroupResponse{}
-	ok := client.redfish.Get(client.procPath, &group)
-	if !ok {
-		return false
-	}
-
+func (client *Client) refreshDellGPUs(ctx context.Context, mc *Collector, s *scope, sys *systemEndpoints) bool {
 	// Get inventory information for Dell GPUs
 
 	dellVideo := DellVideo{}
 
 	// Get dell video inventory
 
-	dellVideoPath := fmt.Sprintf("%s/Oem/Dell/DellVideo", client.systemPath)
-	client.redfish.Get(dellVideoPath, &dellVideo)
+	if client.enabled(config.MetricsInventory, config.MetricsHealth) {
+		dellVideoPath := fmt.Sprintf("%s/Oem/Dell/DellVideo", sys.path)
+		if !client.redfish.Get(ctx, dellVideoPath, &dellVideo) {
+			s.fail(sys.id, "dell_video")
+		}
+	}
 
     // GPU count
     var count = len(dellVideo.Members)
-    mc.NewGPUCount(ch, count)
+    mc.NewGPUCount(s, count)
 
 	// Get dell GPU sensor metrics
 
-    dellGPUSensorPath := fmt.Sprintf("%s/Oem/Dell/DellGPUSensors", client.systemPath)
+    dellGPUSensorPath := fmt.Sprintf("%s/Oem/Dell/DellGPUSensors", sys.path)
 	dellGPUSensors := DellGPUSensors{}
-	if ok := client.redfish.Get(dellGPUSensorPath, &dellGPUSensors); ok {
-		for _, v := range dellGPUSensors.Members {
-			mc.NewBoardPowerSupplyStatus(ch, &v)
-			mc.NewMemoryTemperatureCelsius(ch, &v)
-			mc.NewPowerBrakeStatus(ch, &v)
-			mc.NewPrimaryGPUTemperatureCelsius(ch, &v)
-			mc.NewThermalAlertStatus(ch, &v)
+	if client.enabled(config.MetricsDellOem, config.MetricsThermal) {
+		if client.redfish.Get(ctx, dellGPUSensorPath, &dellGPUSensors) {
+			for _, v := range dellGPUSensors.Members {
+				mc.NewBoardPowerSupplyStatus(s, &v)
+				mc.NewMemoryTemperatureCelsius(s, &v)
+				mc.NewPowerBrakeStatus(s, &v)
+				mc.NewPrimaryGPUTemperatureCelsius(s, &v)
+				mc.NewThermalAlertStatus(s, &v)
+			}
+		} else {
+			s.fail(sys.id, "dell_gpu_sensors")
 		}
 	}
 
-	// Get GPU metrics
+	// Get GPU metrics, the thermal metrics are only derived from the sensors
+	// while the DRAM utilization of dell_oem is part of the processor metrics
 
-	for _, c := range group.Members.GetLinks() {
-		resp := GPU{}
-		ok = client.redfish.Get(c, &resp)
-		if !ok {
-			continue
-		}
+	if !client.enabled(config.MetricsInventory, config.MetricsHealth, config.MetricsUtilization, config.MetricsPower, config.MetricsPCIe, config.MetricsNvidiaOem, config.MetricsDellOem) {
+		return true
+	}
 
+	ok := forEachMember(ctx, client, s, "processor", sys.procPath, nil, func(_ int, c string, resp *GPU) {
 		if resp.ProcessorType != "GPU" {
-			continue
+			return
 		}
 
 		if resp.Status.State != StateEnabled {
-			continue
+			return
 		}
 
 		gpuInfo := GPUInfo{}
@@ -200,115 +393,104 @@ func (client *Client) refreshDellGPUs(mc *Collector, ch chan<- prometheus.Metric
 			if v.Id == resp.Id {
 				gpuInfo.GPUGUID = v.GPUGUID
 				gpuInfo.SerialNumber = v.SerialNumber
-				mc.NewDellGPUState(ch, &v)
-				mc.NewDellGPUHealth(ch, &v)
+				mc.NewDellGPUState(s, &v)
+				mc.NewDellGPUHealth(s, &v)
 				break
 			}
 		}
 
-		mc.NewGPUInfo(ch, &gpuInfo)
+		mc.NewGPUInfo(s, &gpuInfo)
 
-		if resp.Metrics.OdataId != "" {
+		if resp.Metrics.OdataId != "" && client.enabled(config.MetricsUtilization, config.MetricsPower, config.MetricsPCIe, config.MetricsNvidiaOem, config.MetricsDellOem) {
 			gpuMetrics := GPUMetrics{}
-			ok = client.redfish.Get(resp.Metrics.OdataId, &gpuMetrics)
-			if !ok {
-				break
-			}
-
-			mc.NewGPUBandwidthPercent(ch, &gpuMetrics)
-			mc.NewGPUConsumedPowerWatt(ch, &gpuMetrics)
-			mc.NewGPUOperatingSpeedMHz(ch, &gpuMetrics)
-
-			if gpuMetrics.Oem != nil {
-				nvidia := gpuMetrics.Oem.Nvidia
-				if nvidia != nil {
-					mc.NewGPUThrottleReasons(ch, nvidia.ThrottleReasons, gpuMetrics.Id)
-					mc.NewGPUSMUtilizationPercent(ch, nvidia.SMUtilizationPercent, gpuMetrics.Id)
-					mc.NewGPUSMActivityPercent(ch, nvidia.SMActivityPercent, gpuMetrics.Id)
-					mc.NewGPUSMOccupancyPercent(ch, nvidia.SMOccupancyPercent, gpuMetrics.Id)
-					mc.NewGPUTensorCoreActivityPercent(ch, nvidia.TensorCoreActivityPercent, gpuMetrics.Id)
-					mc.NewGPUHMMAUtilizationPercent(ch, nvidia.HMMAUtilizationPercent, gpuMetrics.Id)
-					mc.NewGPUPCIeRawTxBandwidthGbps(ch, nvidia.PCIeRawTxBandwidthGbps, gpuMetrics.Id)
-					mc.NewGPUPCIeRawRxBandwidthGbps(ch, nvidia.PCIeRawRxBandwidthGbps, gpuMetrics.Id)
-				}
-				dell := gpuMetrics.Oem.Dell
-				if dell != nil {
-					mc.NewGPUCurrentPCIeLinkSpeed(ch, dell.CurrentPCIeLinkSpeed, gpuMetrics.Id)
-					mc.NewGPUMaxSupportedPCIeLinkSpeed(ch, dell.MaxSupportedPCIeLinkSpeed, gpuMetrics.Id)
-					mc.NewGPUDRAMUtilizationPercent(ch, dell.DRAMUtilizationPercent, gpuMetrics.Id)
+			ok := client.redfish.Get(ctx, resp.Metrics.OdataId, &gpuMetrics)
+			if ok {
+				mc.NewGPUBandwidthPercent(s, &gpuMetrics)
+				mc.NewGPUConsumedPowerWatt(s, &gpuMetrics)
+				mc.NewGPUOperatingSpeedMHz(s, &gpuMetrics)
+
+				if gpuMetrics.Oem != nil {
+					mc.NewGPUNvidiaMetrics(s, gpuMetrics.Id, &gpuMetrics)
+					dell := gpuMetrics.Oem.Dell
+					if dell != nil {
+						mc.NewGPUCurrentPCIeLinkSpeed(s, dell.CurrentPCIeLinkSpeed, gpuMetrics.Id)
+						mc.NewGPUMaxSupportedPCIeLinkSpeed(s, dell.MaxSupportedPCIeLinkSpeed, gpuMetrics.Id)
+						mc.NewGPUDRAMUtilizationPercent(s, dell.DRAMUtilizationPercent, gpuMetrics.Id)
+					}
 				}
-			}
 
-			if gpuMetrics.PCIeErrors != nil {
-				mc.NewGPUPCIeCorrectableErrorCount(ch, gpuMetrics.PCIeErrors.CorrectableErrorCount, gpuMetrics.Id)
+				if gpuMetrics.PCIeErrors != nil {
+					mc.NewGPUPCIeCorrectableErrorCount(s, gpuMetrics.PCIeErrors.CorrectableErrorCount, gpuMetrics.Id)
+				}
+			} else {
+				s.fail(resp.Id, "processor_metrics")
 			}
 		}
 
-		if resp.MemorySummary.Metrics.OdataId != "" {
+		if resp.MemorySummary.Metrics.OdataId != "" && client.enabled(config.MetricsUtilization) {
 			gpuMemoryMetrics := GPUMemoryMetrics{}
-			ok = client.redfish.Get(resp.MemorySummary.Metrics.OdataId, &gpuMemoryMetrics)
-			if !ok {
-				break
+			ok := client.redfish.Get(ctx, resp.MemorySummary.Metrics.OdataId, &gpuMemoryMetrics)
+			if ok {
+				mc.NewGPUMemoryBandwidthPercent(s, resp.Id, &gpuMemoryMetrics)
+				mc.NewGPUMemoryOperatingSpeedMHz(s, resp.Id, &gpuMemoryMetrics)
+			} else {
+				s.fail(resp.Id, "memory_metrics")
 			}
-
-			mc.NewGPUMemoryBandwidthPercent(ch, resp.Id, &gpuMemoryMetrics)
-			mc.NewGPUMemoryOperatingSpeedMHz(ch, resp.Id, &gpuMemoryMetrics)
 		}
-	}
+	})
 
-	return true
+	return ok
 }
 
 var GPU_REGEXP = regexp.MustCompile(`GPU (.*) Temp`)
 var HBM_REGEXP = regexp.MustCompile(`HBM (.*) Temp`)
 
-func (client *Client) refreshSupermicroGPUs(mc *Collector, ch chan<- prometheus.Metric) bool {
-	group := GroupResponse{}
-	ok := client.redfish.Get(client.devicesPath, &group)
-	if !ok {
-		return false
-	}
-
-	// Get GPU metrics
-    var count = 0
-	for _, c := range group.Members.GetLinks() {
-		if !strings.Contains(c, "GPU") {
-			continue
+func (client *Client) refreshSupermicroGPUs(ctx context.Context, mc *Collector, s *scope, sys *systemEndpoints) bool {
+	// Get GPU inventory and health, the PCIe devices are not needed for the
+	// temperatures
+	if client.enabled(config.MetricsInventory, config.MetricsHealth) {
+		// GPUs are counted by their links, even if their details cannot be fetched
+		count := 0
+		isGPU := func(link string) bool {
+			if strings.Contains(link, "GPU") {
+				count++
+				return true
+			}
+			return false
 		}
-        count++
-
-		resp := PCIeDeviceResponse{}
+		ok := forEachMember(ctx, client, s, "pcie_device", sys.devicesPath, isGPU, func(_ int, c string, resp *PCIeDeviceResponse) {
+			gpuInfo := GPUInfo{}
+			gpuInfo.Id = resp.ID
+			gpuInfo.Model = resp.Model
+			gpuInfo.PartNumber = resp.PartNumber
+			gpuInfo.SerialNumber = resp.SerialNumber
+
+			if resp.Oem != nil && resp.Oem.Supermicro != nil {
+				gpuInfo.Manufacturer = resp.Oem.Supermicro.GPUVendor
+				if resp.Oem.Supermicro.GPUGUID1 != "" {
+					gpuInfo.GPUGUID = resp.Oem.Supermicro.GPUGUID1
+				} else {
+					gpuInfo.GPUGUID = resp.Oem.Supermicro.GPUGUID2
+				}
+				gpuInfo.Slot = resp.Oem.Supermicro.GPUSlot
+			}
 
-		ok = client.redfish.Get(c, &resp)
+			mc.NewGPUInfo(s, &gpuInfo)
+			mc.NewPCIeDeviceGPUHealth(s, resp)
+			mc.NewPCIeDeviceGPUState(s, resp)
+		})
 		if !ok {
-			continue
+			return false
 		}
 
-		gpuInfo := GPUInfo{}
-		gpuInfo.Id = resp.ID
-		gpuInfo.Model = resp.Model
-		gpuInfo.PartNumber = resp.PartNumber
-		gpuInfo.SerialNumber = resp.SerialNumber
-
-		if resp.Oem != nil && resp.Oem.Supermicro != nil {
-			gpuInfo.Manufacturer = resp.Oem.Supermicro.GPUVendor
-			if resp.Oem.Supermicro.GPUGUID1 != "" {
-				gpuInfo.GPUGUID = resp.Oem.Supermicro.GPUGUID1
-			} else {
-				gpuInfo.GPUGUID = resp.Oem.Supermicro.GPUGUID2
-			}
-			gpuInfo.Slot = resp.Oem.Supermicro.GPUSlot
-		}
-
-		mc.NewGPUInfo(ch, &gpuInfo)
-		mc.NewSupermicroGPUHealth(ch, &resp)
-		mc.NewSupermicroGPUState(ch, &resp)
+		mc.NewGPUCount(s, count)
 	}
 
-    mc.NewGPUCount(ch, count)
-
 	thermalResp := ThermalResponse{}
-	ok = client.redfish.Get(client.thermalPath, &thermalResp)
+	ok := client.enabled(config.MetricsThermal) && client.redfish.Get(ctx, sys.thermalPath, &thermalResp)
+	if !ok && client.enabled(config.MetricsThermal) {
+		s.fail(sys.id, "thermal")
+	}
 
 	if ok {
 		for _, t := range thermalResp.Temperatures {
@@ -319,7 +501,7 @@ func (client *Client) refreshSupermicroGPUs(mc *Collector, ch chan<- prometheus.
 						temp, err := strconv.ParseFloat(value, 64)
 						if err == nil {
 							id := "GPU" + matches[1]
-							mc.NewSmcGPUTemp(ch, id, temp)
+							mc.NewGPUTemperatureCelsius(s, id, temp)
 						}
 					}
 				}
@@ -333,7 +515,7 @@ func (client *Client) refreshSupermicroGPUs(mc *Collector, ch chan<- prometheus.
 						temp, err := strconv.ParseFloat(value, 64)
 						if err == nil {
 							id := "GPU" + matches[1]
-							mc.NewSmcGPUMemoryTemp(ch, id, temp)
+							mc.NewGPUMemoryTemperatureCelsius(s, id, temp)
 						}
 					}
 				}
@@ -344,7 +526,7 @@ func (client *Client) refreshSupermicroGPUs(mc *Collector, ch chan<- prometheus.
 			matches := re.FindStringSubmatch(t.Name)
 			if matches != nil {
 				id := matches[1]
-				mc.NewSmcGPUTemp(ch, id, t.ReadingCelsius)
+				mc.NewGPUTemperatureCelsius(s, id, t.ReadingCelsius)
 				continue
 			}
 		}
@@ -352,3 +534,74 @@ func (client *Client) refreshSupermicroGPUs(mc *Collector, ch chan<- prometheus.
 
 	return true
 }
+
+// Get the temperature and power sensors related to the given GPUs, which are
+// indexed by the path of their resource. Readings already in seen are skipped.
+func (client *Client) refreshGPUSensors(ctx context.Context, mc *Collector, s *scope, sys *systemEndpoints, gpus map[string]string, seen map[string]bool) bool {
+	if sys.sensorsPath == "" || !client.enabled(config.MetricsThermal, config.MetricsPower) {
+		return false
+	}
+
+	// Fetch the sensors first, so that the order in which they are used does
+	// not depend on the order of the responses
+	mutex := sync.Mutex{}
+	sensors := map[int]*SensorResponse{}
+	ok := forEachMember(ctx, client, s, "sensor", sys.sensorsPath, nil, func(i int, _ string, sensor *SensorResponse) {
+		mutex.Lock()
+		sensors[i] = sensor
+		mutex.Unlock()
+	})
+	if !ok {
+		s.fail(sys.id, "sensors")
+		return false
+	}
+
+	indexes := []int{}
+	for i := range sensors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		sensor := sensors[i]
+		if sensor.Reading == nil {
+			continue
+		}
+
+		if sensor.Status.State != "" && sensor.Status.State != StateEnabled {
+			continue
+		}
+
+		id := ""
+		for _, r := range sensor.RelatedItem {
+			if v, ok := gpus[r.OdataId]; ok {
+				id = v
+				break
+			}
+		}
+		if id == "" {
+			continue
+		}
+
+		// Only use the first sensor of a kind for each GPU
+		kind := sensor.ReadingType
+		if kind == "Temperature" && sensor.PhysicalContext == "Memory" {
+			kind = "MemoryTemperature"
+		}
+		if seen[id+kind] {
+			continue
+		}
+		seen[id+kind] = true
+
+		switch kind {
+		case "Temperature":
+			mc.NewGPUTemperatureCelsius(s, id, *sensor.Reading)
+		case "MemoryTemperature":
+			mc.NewGPUMemoryTemperatureCelsius(s, id, *sensor.Reading)
+		case "Power":
+			mc.NewGPUConsumedPower(s, id, *sensor.Reading)
+		}
+	}
+
+	return true
+}
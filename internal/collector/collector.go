@@ -1,12 +1,15 @@
 package collector
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/firmus-public/oob_gpu_exporter/internal/config"
 	"github.com/firmus-public/oob_gpu_exporter/internal/version"
@@ -17,18 +20,37 @@ import (
 var mu sync.Mutex
 var collectors = map[string]*Collector{}
 
+// Returned for a collector that was evicted after it was requested, so that
+// it does not create a session nobody deletes
+var errClosed = errors.New("collector was closed")
+
 type Collector struct {
 	// Internal variables
-	client     *Client
-	registry   *prometheus.Registry
-	collected  *sync.Cond
-	collecting bool
-	errors     atomic.Uint64
-	builder    *strings.Builder
+	client      *Client
+	registry    *prometheus.Registry
+	collected   *sync.Cond
+	collecting  bool
+	closed      bool
+	errors      atomic.Uint64
+	lastSuccess atomic.Int64
+	lastUsed    atomic.Int64
+	builder     *strings.Builder
+	groups      map[*prometheus.Desc]string
+
+	// Context of the ongoing collection, as the registry does not pass one
+	// to Collect
+	ctx context.Context
 
 	// Exporter
-	ExporterBuildInfo         *prometheus.Desc
-	ExporterScrapeErrorsTotal *prometheus.Desc
+	ExporterBuildInfo            *prometheus.Desc
+	ExporterScrapeErrorsTotal    *prometheus.Desc
+	ExporterLastSuccessTimestamp *prometheus.Desc
+	ExporterScrapeRequests       *prometheus.Desc
+	ExporterUp                   *prometheus.Desc
+	ExporterScrapeDuration       *prometheus.Desc
+	ExporterScrapePhaseDuration  *prometheus.Desc
+	ExporterBMCCertExpiry        *prometheus.Desc
+	ExporterScrapeTimedOut       *prometheus.Desc
 
 	// GPUs
 	GPUCount                        *prometheus.Desc
@@ -57,10 +79,18 @@ type Collector struct {
 	GPUMaxSupportedPCIeLinkSpeed    *prometheus.Desc
 	GPUDRAMUtilizationPercent       *prometheus.Desc
 	GPUPCIeCorrectableErrorCount    *prometheus.Desc
+	GPUMemoryCorrectableECCErrors   *prometheus.Desc
+	GPUMemoryUncorrectableECCErrors *prometheus.Desc
+	GPUNVLinkUp                     *prometheus.Desc
+	GPUNVLinkSpeedGbps              *prometheus.Desc
+	GPUNVLinkErrorCount             *prometheus.Desc
+	NVSwitchHealth                  *prometheus.Desc
+	NVSwitchTemperatureCelsius      *prometheus.Desc
+	GPUCollectionErrors             *prometheus.Desc
 }
 
 func NewCollector() *Collector {
-	prefix := config.Config.MetricsPrefix
+	prefix := config.Current().MetricsPrefix
 
 	collector := &Collector{
 		ExporterBuildInfo: prometheus.NewDesc(
@@ -77,136 +107,247 @@ func NewCollector() *Collector {
 			"Total number of errors encountered while scraping target",
 			nil, nil,
 		),
+		ExporterLastSuccessTimestamp: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu_exporter", "last_success_timestamp_seconds"),
+			"Unix timestamp of the last successful collection from target",
+			nil, nil,
+		),
+		ExporterScrapeRequests: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu_exporter", "scrape_requests"),
+			"Number of Redfish requests sent to target during the scrape",
+			nil, nil,
+		),
+		ExporterUp: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu_exporter", "up"),
+			"Whether the GPUs of target could be collected",
+			nil, nil,
+		),
+		ExporterScrapeDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu_exporter", "scrape_duration_seconds"),
+			"Duration of the scrape of target in seconds",
+			nil, nil,
+		),
+		ExporterScrapePhaseDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu_exporter", "scrape_phase_duration_seconds"),
+			"Duration of the phases of the scrape of target in seconds",
+			[]string{"phase"}, nil,
+		),
+		ExporterBMCCertExpiry: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu_exporter", "bmc_cert_expiry_timestamp_seconds"),
+			"Unix timestamp of the expiry of the TLS certificate of target",
+			nil, nil,
+		),
+		ExporterScrapeTimedOut: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu_exporter", "scrape_timed_out"),
+			"Whether the scrape of target timed out and returned partial results",
+			nil, nil,
+		),
         GPUCount: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "num_gpus"),
 			"The number of GPUs detected",
-			nil, nil,
+			[]string{"system", "chassis"}, nil,
 		),
 		GPUInfo: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "info"),
 			"Information about the GPU",
-			[]string{"id", "manufacturer", "model", "part_number", "serial_number", "guid", "slot"}, nil,
+			[]string{"id", "manufacturer", "model", "part_number", "serial_number", "guid", "slot", "system", "chassis"}, nil,
 		),
 		GPUState: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "state"),
 			"State of the GPU",
-			[]string{"id", "state"}, nil,
+			[]string{"id", "state", "system", "chassis"}, nil,
 		),
 		GPUHealth: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "health"),
 			"Health status of the GPU",
-			[]string{"id", "status"}, nil,
+			[]string{"id", "status", "system", "chassis"}, nil,
 		),
 		GPUBoardPowerSupplyStatus: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "board_power_supply_status"),
 			"Status of the GPU board power supply",
-			[]string{"id", "status"}, nil,
+			[]string{"id", "status", "system", "chassis"}, nil,
 		),
 		GPUMemoryTemperatureCelsius: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "memory_temperature_celsius"),
 			"Temperature of the GPU memory in celsius",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUPowerBrakeStatus: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "power_brake_status"),
 			"Status of the GPU power brake",
-			[]string{"id", "status"}, nil,
+			[]string{"id", "status", "system", "chassis"}, nil,
 		),
 		GPUPrimaryGPUTemperatureCelsius: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "primary_gpu_temperature_celsius"),
 			"Primary temperature of the GPU in celsius",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUThermalAlertStatus: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "thermal_alert_status"),
 			"Thermal alert status of the GPU",
-			[]string{"id", "status"}, nil,
+			[]string{"id", "status", "system", "chassis"}, nil,
 		),
 		GPUBandwidthPercent: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "bandwidth_percent"),
 			"Utilization of the GPU in percent",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUConsumedPowerWatt: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "consumed_power_watt"),
 			"Power consumed by the GPU in watts",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUOperatingSpeedMHz: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "operating_speed_mhz"),
 			"Operating speed of the GPU in Mhz",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUMemoryBandwidthPercent: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "memory_bandwidth_percent"),
 			"Utilization of the GPU memory in percent",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUMemoryOperatingSpeedMHz: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "memory_operating_speed_mhz"),
 			"Operating speed of the GPU memory in Mhz",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUThrottleReason: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "throttle_reason"),
 			"Reason for GPU throttling",
-			[]string{"id", "reason"}, nil,
+			[]string{"id", "reason", "system", "chassis"}, nil,
 		),
 		GPUSMUtilizationPercent: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "sm_utilization_percent"),
 			"Streaming Multiprocessor (SM) utilization of the GPU in percent",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUSMActivityPercent: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "sm_activity_percent"),
 			"Streaming Multiprocessor (SM) activity of the GPU in percent",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUSMOccupancyPercent: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "sm_occupancy_percent"),
 			"Streaming Multiprocessor (SM) occupancy of the GPU in percent",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUTensorCoreActivityPercent: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "tensor_core_activity_percent"),
 			"Tensor Core activity of the GPU in percent",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUHMMAUtilizationPercent: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "hmma_utilization_percent"),
 			"HMMA (Hybrid Matrix Multiply-Accumulate) utilization of the GPU in percent",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUPCIeRawTxBandwidthGbps: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "pcie_raw_tx_bandwidth_gbps"),
 			"PCIe raw transmit bandwidth of the GPU in Gbps",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUPCIeRawRxBandwidthGbps: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "pcie_raw_rx_bandwidth_gbps"),
 			"PCIe raw receive bandwidth of the GPU in Gbps",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUCurrentPCIeLinkSpeed: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "current_pcie_link_speed"),
 			"Current PCIe link speed of the GPU",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUMaxSupportedPCIeLinkSpeed: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "max_supported_pcie_link_speed"),
 			"Maximum supported PCIe link speed of the GPU",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUDRAMUtilizationPercent: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "dram_utilization_percent"),
 			"DRAM utilization of the GPU in percent",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
 		),
 		GPUPCIeCorrectableErrorCount: prometheus.NewDesc(
 			prometheus.BuildFQName(prefix, "gpu", "pcie_correctable_error_count"),
 			"Number of correctable PCIe errors of the GPU",
-			[]string{"id"}, nil,
+			[]string{"id", "system", "chassis"}, nil,
+		),
+		GPUMemoryCorrectableECCErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu", "memory_correctable_ecc_error_count"),
+			"Lifetime number of correctable ECC errors of the GPU memory",
+			[]string{"id", "system", "chassis"}, nil,
+		),
+		GPUMemoryUncorrectableECCErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu", "memory_uncorrectable_ecc_error_count"),
+			"Lifetime number of uncorrectable ECC errors of the GPU memory",
+			[]string{"id", "system", "chassis"}, nil,
+		),
+		GPUNVLinkUp: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu", "nvlink_up"),
+			"Whether the NVLink port of the GPU is up",
+			[]string{"id", "port", "system", "chassis"}, nil,
 		),
+		GPUNVLinkSpeedGbps: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu", "nvlink_speed_gbps"),
+			"Current speed of the NVLink port of the GPU in Gbps",
+			[]string{"id", "port", "system", "chassis"}, nil,
+		),
+		GPUNVLinkErrorCount: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu", "nvlink_error_count"),
+			"Number of errors on the NVLink port of the GPU",
+			[]string{"id", "port", "type", "system", "chassis"}, nil,
+		),
+		NVSwitchHealth: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu", "nvswitch_health"),
+			"Health status of the NVSwitch",
+			[]string{"id", "status", "system", "chassis"}, nil,
+		),
+		NVSwitchTemperatureCelsius: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu", "nvswitch_temperature_celsius"),
+			"Temperature of the NVSwitch in celsius",
+			[]string{"id", "system", "chassis"}, nil,
+		),
+		GPUCollectionErrors: prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "gpu", "collection_errors"),
+			"Number of requests for resources of the GPU or system that failed during the scrape",
+			[]string{"id", "resource", "system", "chassis"}, nil,
+		),
+	}
+
+	collector.groups = map[*prometheus.Desc]string{
+		collector.GPUCount:                        config.MetricsInventory,
+		collector.GPUInfo:                         config.MetricsInventory,
+		collector.GPUState:                        config.MetricsHealth,
+		collector.GPUHealth:                       config.MetricsHealth,
+		collector.GPUMemoryCorrectableECCErrors:   config.MetricsHealth,
+		collector.GPUMemoryUncorrectableECCErrors: config.MetricsHealth,
+		collector.NVSwitchHealth:                  config.MetricsHealth,
+		collector.GPUPrimaryGPUTemperatureCelsius: config.MetricsThermal,
+		collector.GPUMemoryTemperatureCelsius:     config.MetricsThermal,
+		collector.NVSwitchTemperatureCelsius:      config.MetricsThermal,
+		collector.GPUConsumedPowerWatt:            config.MetricsPower,
+		collector.GPUBandwidthPercent:             config.MetricsUtilization,
+		collector.GPUOperatingSpeedMHz:            config.MetricsUtilization,
+		collector.GPUMemoryBandwidthPercent:       config.MetricsUtilization,
+		collector.GPUMemoryOperatingSpeedMHz:      config.MetricsUtilization,
+		collector.GPUPCIeCorrectableErrorCount:    config.MetricsPCIe,
+		collector.GPUPCIeRawTxBandwidthGbps:       config.MetricsPCIe,
+		collector.GPUPCIeRawRxBandwidthGbps:       config.MetricsPCIe,
+		collector.GPUCurrentPCIeLinkSpeed:         config.MetricsPCIe,
+		collector.GPUMaxSupportedPCIeLinkSpeed:    config.MetricsPCIe,
+		collector.GPUNVLinkUp:                     config.MetricsNVLink,
+		collector.GPUNVLinkSpeedGbps:              config.MetricsNVLink,
+		collector.GPUNVLinkErrorCount:             config.MetricsNVLink,
+		collector.GPUThrottleReason:               config.MetricsNvidiaOem,
+		collector.GPUSMUtilizationPercent:         config.MetricsNvidiaOem,
+		collector.GPUSMActivityPercent:            config.MetricsNvidiaOem,
+		collector.GPUSMOccupancyPercent:           config.MetricsNvidiaOem,
+		collector.GPUTensorCoreActivityPercent:    config.MetricsNvidiaOem,
+		collector.GPUHMMAUtilizationPercent:       config.MetricsNvidiaOem,
+		collector.GPUBoardPowerSupplyStatus:       config.MetricsDellOem,
+		collector.GPUPowerBrakeStatus:             config.MetricsDellOem,
+		collector.GPUThermalAlertStatus:           config.MetricsDellOem,
+		collector.GPUDRAMUtilizationPercent:       config.MetricsDellOem,
 	}
 
 	collector.builder = new(strings.Builder)
@@ -220,6 +361,13 @@ func NewCollector() *Collector {
 func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- collector.ExporterBuildInfo
 	ch <- collector.ExporterScrapeErrorsTotal
+	ch <- collector.ExporterLastSuccessTimestamp
+	ch <- collector.ExporterScrapeRequests
+	ch <- collector.ExporterUp
+	ch <- collector.ExporterScrapeDuration
+	ch <- collector.ExporterScrapePhaseDuration
+	ch <- collector.ExporterBMCCertExpiry
+	ch <- collector.ExporterScrapeTimedOut
 	ch <- collector.GPUCount
 	ch <- collector.GPUInfo
 	ch <- collector.GPUHealth
@@ -246,21 +394,84 @@ func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- collector.GPUMaxSupportedPCIeLinkSpeed
 	ch <- collector.GPUDRAMUtilizationPercent
 	ch <- collector.GPUPCIeCorrectableErrorCount
+	ch <- collector.GPUMemoryCorrectableECCErrors
+	ch <- collector.GPUMemoryUncorrectableECCErrors
+	ch <- collector.GPUNVLinkUp
+	ch <- collector.GPUNVLinkSpeedGbps
+	ch <- collector.GPUNVLinkErrorCount
+	ch <- collector.NVSwitchHealth
+	ch <- collector.NVSwitchTemperatureCelsius
+	ch <- collector.GPUCollectionErrors
 }
 
 func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
-	collector.client.redfish.RefreshSession()
+	ctx := collector.ctx
+	start := time.Now()
+	requests := collector.client.redfish.Requests()
+
+	// The session is created together with the discovery of the systems,
+	// which is retried on every scrape until the host could be reached once
+	discovered := collector.client.Discovered()
+
+	phase := time.Now()
+	// A session is kept if the discovery timed out, so it is refreshed as well
+	if discovered || collector.client.redfish.session.token != "" {
+		collector.client.redfish.RefreshSession(ctx)
+	} else {
+		collector.client.redfish.CreateSession(ctx)
+	}
+	sessionDuration := time.Since(phase)
+
+	phase = time.Now()
+	if !discovered {
+		discovered = collector.client.Discover(ctx)
+	}
+	discoveryDuration := time.Since(phase)
+
+	phase = time.Now()
+	ok := discovered && collector.client.RefreshGPUs(ctx, collector, ch)
+	gpusDuration := time.Since(phase)
 
-	ok := collector.client.RefreshGPUs(collector, ch)
+	// The results of a scrape that timed out are incomplete
+	timedOut := 0.0
+	if ctx.Err() != nil {
+		timedOut = 1
+		ok = false
+	}
+
+	up := 0.0
 	if !ok {
 		collector.errors.Add(1)
+	} else {
+		up = 1
+		collector.lastSuccess.Store(time.Now().UnixNano())
 	}
 
 	ch <- prometheus.MustNewConstMetric(collector.ExporterBuildInfo, prometheus.UntypedValue, 1)
 	ch <- prometheus.MustNewConstMetric(collector.ExporterScrapeErrorsTotal, prometheus.CounterValue, float64(collector.errors.Load()))
+	ch <- prometheus.MustNewConstMetric(collector.ExporterUp, prometheus.GaugeValue, up)
+
+	ch <- prometheus.MustNewConstMetric(collector.ExporterScrapeRequests, prometheus.GaugeValue, float64(collector.client.redfish.Requests()-requests))
+
+	if last := collector.lastSuccess.Load(); last > 0 {
+		ch <- prometheus.MustNewConstMetric(collector.ExporterLastSuccessTimestamp, prometheus.GaugeValue, float64(last)/1e9)
+	}
+
+	if expiry, ok := collector.client.redfish.CertExpiry(); ok {
+		ch <- prometheus.MustNewConstMetric(collector.ExporterBMCCertExpiry, prometheus.GaugeValue, float64(expiry.Unix()))
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.ExporterScrapeTimedOut, prometheus.GaugeValue, timedOut)
+
+	ch <- prometheus.MustNewConstMetric(collector.ExporterScrapePhaseDuration, prometheus.GaugeValue, sessionDuration.Seconds(), "session")
+	ch <- prometheus.MustNewConstMetric(collector.ExporterScrapePhaseDuration, prometheus.GaugeValue, discoveryDuration.Seconds(), "discovery")
+	ch <- prometheus.MustNewConstMetric(collector.ExporterScrapePhaseDuration, prometheus.GaugeValue, gpusDuration.Seconds(), "gpus")
+	ch <- prometheus.MustNewConstMetric(collector.ExporterScrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds())
 }
 
-func (collector *Collector) Gather() (string, error) {
+// Collects the metrics of the target, the Redfish requests are cancelled when
+// the context is done and the metrics collected so far are returned
+func (collector *Collector) Gather(ctx context.Context) (string, error) {
 	collector.collected.L.Lock()
 
 	// If a collection is already in progress wait for it to complete and return the cached data
@@ -271,6 +482,11 @@ func (collector *Collector) Gather() (string, error) {
 		return metrics, nil
 	}
 
+	if collector.closed {
+		collector.collected.L.Unlock()
+		return "", errClosed
+	}
+
 	// Set collecting to true and let other goroutines enter in critical section
 	collector.collecting = true
 	collector.collected.L.Unlock()
@@ -285,58 +501,200 @@ func (collector *Collector) Gather() (string, error) {
 
 	// Collect metrics
 	collector.builder.Reset()
+	collector.ctx = ctx
 
-	m, err := collector.registry.Gather()
+	err := gatherText(collector.registry, collector.builder)
 	if err != nil {
 		return "", err
 	}
 
+	return collector.builder.String(), nil
+}
+
+// Writes the metrics of the registry to the builder in the text format
+func gatherText(registry *prometheus.Registry, builder *strings.Builder) error {
+	m, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+
 	for i := range m {
-		_, err := expfmt.MetricFamilyToText(collector.builder, m[i])
+		_, err := expfmt.MetricFamilyToText(builder, m[i])
 		if err != nil {
 			log.Printf("Error converting metric to text: %v", err)
 		}
 	}
 
-	return collector.builder.String(), nil
+	return nil
+}
+
+// Collects the metrics of a target that could not be collected at all, which
+// only report that it is down
+type unavailableCollector struct {
+	*Collector
+	start time.Time
 }
 
-// Resets an existing collector of the given target
+func (collector unavailableCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(collector.ExporterBuildInfo, prometheus.UntypedValue, 1)
+	ch <- prometheus.MustNewConstMetric(collector.ExporterUp, prometheus.GaugeValue, 0)
+	ch <- prometheus.MustNewConstMetric(collector.ExporterScrapeDuration, prometheus.GaugeValue, time.Since(collector.start).Seconds())
+}
+
+// Returns the metrics of a target without a collector or without a recent
+// snapshot, so that it is reported as down instead of failing the scrape
+func UnavailableMetrics(start time.Time) string {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(unavailableCollector{NewCollector(), start})
+
+	builder := new(strings.Builder)
+	if err := gatherText(registry, builder); err != nil {
+		log.Printf("Error gathering metrics of unavailable target: %v", err)
+	}
+
+	return builder.String()
+}
+
+// Returns the metrics whose group is not selected
+func (collector *Collector) disabledMetrics(metrics config.MetricsConfig) map[*prometheus.Desc]bool {
+	disabled := map[*prometheus.Desc]bool{}
+	for desc, group := range collector.groups {
+		if !metrics.Enabled(group) {
+			disabled[desc] = true
+		}
+	}
+	return disabled
+}
+
+// Returns the time of the last successful collection
+func (collector *Collector) LastSuccess() time.Time {
+	return time.Unix(0, collector.lastSuccess.Load())
+}
+
+// Returns the targets of all existing collectors
+func Targets() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	targets := make([]string, 0, len(collectors))
+	for target := range collectors {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// Resets an existing collector of the given target, its session is deleted
+// in the background
 func Reset(target string) {
 	mu.Lock()
 	_, ok := collectors[target]
 	if ok {
-		delete(collectors, target)
+		evict(target)
+	}
+	mu.Unlock()
+}
+
+// Waits for an ongoing collection and deletes the session of the client, so
+// that the BMC does not run out of sessions, and closes its connections
+func (collector *Collector) Close() {
+	collector.collected.L.Lock()
+	defer collector.collected.L.Unlock()
+
+	for collector.collecting {
+		collector.collected.Wait()
+	}
+
+	if collector.client != nil {
+		collector.client.redfish.DeleteSession(context.Background())
+		collector.client.redfish.http.CloseIdleConnections()
 	}
+}
+
+// Stops the pollers and closes all collectors, an error is returned if not
+// all of them are closed before the context is done
+func Shutdown(ctx context.Context) error {
+	StopPollers()
+
+	mu.Lock()
+	closing := collectors
+	collectors = map[string]*Collector{}
 	mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, c := range closing {
+			wg.Add(1)
+			go func(c *Collector) {
+				defer wg.Done()
+				c.Close()
+			}(c)
+		}
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func GetCollector(target string) (*Collector, error) {
 	mu.Lock()
 	collector, ok := collectors[target]
 	if !ok {
+		limit := config.Current().MaxCollectors
+		if limit > 0 && uint(len(collectors)) >= limit {
+			evictLeastRecentlyUsed(polledTargets())
+		}
 		collector = NewCollector()
 		collectors[target] = collector
 	}
+	collector.lastUsed.Store(time.Now().UnixNano())
 	mu.Unlock()
 
 	// Do not act concurrently on the same host
 	collector.collected.L.Lock()
 	defer collector.collected.L.Unlock()
 
-	// Try to instantiate a new Redfish host
+	if collector.closed {
+		return nil, errClosed
+	}
+
+	// The host is only contacted once metrics are collected, so that an
+	// unreachable host is reported by the metrics of the exporter
 	if collector.client == nil {
 		host := config.GetHostConfig(target)
 		if host == nil {
 			return nil, fmt.Errorf("failed to get host information")
 		}
-		c := NewClient(host)
-		if c == nil {
-			return nil, fmt.Errorf("failed to instantiate new client")
-		} else {
-			collector.client = c
+		c, err := NewClient(host)
+		if err != nil {
+			return nil, err
 		}
+		collector.client = c
 	}
 
 	return collector, nil
 }
+
+// Gets the collector of the target and collects its metrics. If the collector
+// is evicted in between, the metrics are collected by a new one.
+func GatherTarget(ctx context.Context, target string) (*Collector, string, error) {
+	for attempt := 1; ; attempt++ {
+		c, err := GetCollector(target)
+		if err == nil {
+			var metrics string
+			metrics, err = c.Gather(ctx)
+			if err == nil {
+				return c, metrics, nil
+			}
+		}
+		if !errors.Is(err, errClosed) || attempt == 3 {
+			return nil, "", err
+		}
+	}
+}
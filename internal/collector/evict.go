@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+	"github.com/firmus-public/oob_gpu_exporter/internal/log"
+)
+
+// Interval of the checks for idle collectors
+var evictionInterval = 10 * time.Second
+
+// Starts evicting the collectors of targets that were not scraped for longer
+// than the idle timeout, which also deletes their sessions
+func StartEviction() {
+	go func() {
+		ticker := time.NewTicker(evictionInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			evictIdle()
+		}
+	}()
+}
+
+// Returns the targets polled in the background, whose collectors are never
+// evicted
+func polledTargets() map[string]bool {
+	pollersMutex.Lock()
+	defer pollersMutex.Unlock()
+
+	polled := map[string]bool{}
+	for target := range pollers {
+		polled[target] = true
+	}
+	return polled
+}
+
+// Evicts idle collectors except the ones of targets polled in the background,
+// an idle timeout of 0 disables the eviction
+func evictIdle() {
+	idle := time.Duration(config.Current().CollectorIdleTimeout) * time.Second
+	if idle == 0 {
+		return
+	}
+
+	polled := polledTargets()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for target, c := range collectors {
+		if !polled[target] && time.Since(c.LastUsed()) > idle {
+			log.Info("Evicting collector of host %s idle since %v", target, c.LastUsed())
+			evict(target)
+		}
+	}
+}
+
+// Evicts the least recently used collector of a target that is not polled to
+// make room for a new one, the caller has to hold the lock of the collectors
+func evictLeastRecentlyUsed(polled map[string]bool) {
+	lru := ""
+	for target, c := range collectors {
+		if polled[target] {
+			continue
+		}
+		if lru == "" || c.LastUsed().Before(collectors[lru].LastUsed()) {
+			lru = target
+		}
+	}
+
+	if lru != "" {
+		log.Info("Evicting collector of host %s as the maximum number of collectors is reached", lru)
+		evict(lru)
+	}
+}
+
+// Removes the collector of the target and deletes its session and the series
+// of its requests in the background, the caller has to hold the lock of the
+// collectors. The collector is marked as closed right away, so that a scrape
+// that got it before does not create a new session.
+func evict(target string) {
+	c := collectors[target]
+	delete(collectors, target)
+
+	c.collected.L.Lock()
+	c.closed = true
+	c.collected.L.Unlock()
+
+	go func() {
+		c.Close()
+		// The request deleting the session is recorded as well
+		deleteTargetMetrics(target)
+	}()
+}
+
+// Returns the time the collector was last requested
+func (collector *Collector) LastUsed() time.Time {
+	return time.Unix(0, collector.lastUsed.Load())
+}
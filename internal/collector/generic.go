@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+)
+
+// Collect GPUs using only resources defined by the DMTF Redfish schemas, which
+// is used for any vendor without a dedicated implementation
+func (client *Client) refreshGenericGPUs(ctx context.Context, mc *Collector, s *scope, sys *systemEndpoints) bool {
+	// Get GPU inventory and metrics
+	gpus := map[string]string{}
+	seen := map[string]bool{}
+	mutex := sync.Mutex{}
+	ok := forEachMember(ctx, client, s, "processor", sys.procPath, nil, func(_ int, c string, resp *GPU) {
+		if resp.ProcessorType != "GPU" {
+			return
+		}
+
+		temperature, power := client.refreshProcessorGPU(ctx, mc, s, resp, resp.Location.PartLocation.LocationOrdinalValue)
+
+		mutex.Lock()
+		gpus[c] = resp.Id
+		seen[resp.Id+"Temperature"] = temperature
+		seen[resp.Id+"Power"] = power
+		mutex.Unlock()
+	})
+	if !ok {
+		return false
+	}
+
+	mc.NewGPUCount(s, len(gpus))
+
+	// Fill in what is missing from the environment metrics
+	client.refreshGPUSensors(ctx, mc, s, sys, gpus, seen)
+
+	return true
+}
+
+// Collects a GPU of the Processors collection from the processor itself and
+// the metrics resources it links to. Returns whether the environment metrics
+// held its temperature and power.
+func (client *Client) refreshProcessorGPU(ctx context.Context, mc *Collector, s *scope, resp *GPU, slot int) (temperature bool, power bool) {
+	gpuInfo := GPUInfo{}
+	gpuInfo.Id = resp.Id
+	gpuInfo.Manufacturer = resp.Manufacturer
+	gpuInfo.Model = resp.Model
+	gpuInfo.PartNumber = resp.PartNumber
+	gpuInfo.SerialNumber = resp.SerialNumber
+	gpuInfo.GPUGUID = resp.UUID
+	gpuInfo.Slot = slot
+
+	mc.NewGPUInfo(s, &gpuInfo)
+	mc.NewProcessorGPUHealth(s, resp)
+	mc.NewProcessorGPUState(s, resp)
+
+	if resp.Metrics.OdataId != "" && client.enabled(config.MetricsUtilization, config.MetricsPCIe, config.MetricsNvidiaOem) {
+		gpuMetrics := GPUMetrics{}
+		ok := client.redfish.Get(ctx, resp.Metrics.OdataId, &gpuMetrics)
+		if ok {
+			// The Id of the metrics resource is usually not the Id of the GPU
+			gpuMetrics.Id = resp.Id
+
+			mc.NewGPUBandwidthPercent(s, &gpuMetrics)
+			mc.NewGPUOperatingSpeedMHz(s, &gpuMetrics)
+
+			mc.NewGPUNvidiaMetrics(s, resp.Id, &gpuMetrics)
+
+			if gpuMetrics.PCIeErrors != nil {
+				mc.NewGPUPCIeCorrectableErrorCount(s, gpuMetrics.PCIeErrors.CorrectableErrorCount, resp.Id)
+			}
+		} else {
+			s.fail(resp.Id, "processor_metrics")
+		}
+	}
+
+	if resp.MemorySummary.Metrics.OdataId != "" && client.enabled(config.MetricsUtilization, config.MetricsHealth) {
+		gpuMemoryMetrics := GPUMemoryMetrics{}
+		ok := client.redfish.Get(ctx, resp.MemorySummary.Metrics.OdataId, &gpuMemoryMetrics)
+		if ok {
+			mc.NewGPUMemoryBandwidthPercent(s, resp.Id, &gpuMemoryMetrics)
+			mc.NewGPUMemoryOperatingSpeedMHz(s, resp.Id, &gpuMemoryMetrics)
+			mc.NewGPUMemoryECCErrors(s, resp.Id, &gpuMemoryMetrics)
+		} else {
+			s.fail(resp.Id, "memory_metrics")
+		}
+	}
+
+	if resp.EnvironmentMetrics.OdataId != "" && client.enabled(config.MetricsThermal, config.MetricsPower) {
+		environment := EnvironmentMetrics{}
+		ok := client.redfish.Get(ctx, resp.EnvironmentMetrics.OdataId, &environment)
+		if ok {
+			t := environment.TemperatureCelsius
+			if t != nil && t.Reading != nil {
+				mc.NewGPUTemperatureCelsius(s, resp.Id, *t.Reading)
+				temperature = true
+			}
+			p := environment.PowerWatts
+			if p != nil && p.Reading != nil {
+				mc.NewGPUConsumedPower(s, resp.Id, *p.Reading)
+				power = true
+			}
+		} else {
+			s.fail(resp.Id, "environment_metrics")
+		}
+	}
+
+	return temperature, power
+}
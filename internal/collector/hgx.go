@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+)
+
+var SXM_REGEXP = regexp.MustCompile(`GPU_SXM_(\d+)$`)
+
+// Collect GPUs from the HGX management controller (HMC), which exposes the
+// GPUs of the baseboard as Systems/HGX_Baseboard_0/Processors/GPU_SXM_<n>
+// and the NVSwitches as Chassis/HGX_NVSwitch_<n>
+func (client *Client) refreshHgxGPUs(ctx context.Context, mc *Collector, s *scope, sys *systemEndpoints) bool {
+	// Get GPU inventory and metrics
+	var count atomic.Int64
+	ok := forEachMember(ctx, client, s, "processor", sys.procPath, nil, func(_ int, c string, resp *GPU) {
+		if resp.ProcessorType != "GPU" {
+			return
+		}
+		count.Add(1)
+
+		// GPUs are identified by their SXM index
+		slot := 0
+		matches := SXM_REGEXP.FindStringSubmatch(resp.Id)
+		if matches != nil {
+			slot, _ = strconv.Atoi(matches[1])
+		}
+
+		client.refreshProcessorGPU(ctx, mc, s, resp, slot)
+
+		if resp.Ports.OdataId != "" && client.enabled(config.MetricsNVLink) {
+			client.refreshNVLinks(ctx, mc, s, resp.Id, resp.Ports.OdataId)
+		}
+	})
+	if !ok {
+		return false
+	}
+
+	mc.NewGPUCount(s, int(count.Load()))
+
+	// Get NVSwitch health and temperature
+	if client.enabled(config.MetricsHealth, config.MetricsThermal) {
+		client.forEach(ctx, sys.nvswitchPaths, func(_ int, c string) {
+			nvswitch := ChassisResponse{}
+			ok := client.redfish.Get(ctx, c, &nvswitch)
+			if !ok {
+				s.fail(path.Base(c), "nvswitch")
+				return
+			}
+
+			mc.NewNVSwitchHealth(s, &nvswitch)
+
+			if nvswitch.EnvironmentMetrics.OdataId != "" && client.enabled(config.MetricsThermal) {
+				environment := EnvironmentMetrics{}
+				ok := client.redfish.Get(ctx, nvswitch.EnvironmentMetrics.OdataId, &environment)
+				if ok {
+					t := environment.TemperatureCelsius
+					if t != nil && t.Reading != nil {
+						mc.NewNVSwitchTemperatureCelsius(s, nvswitch.Id, *t.Reading)
+					}
+				} else {
+					s.fail(nvswitch.Id, "environment_metrics")
+				}
+			}
+		})
+	}
+
+	return true
+}
+
+func (client *Client) refreshNVLinks(ctx context.Context, mc *Collector, s *scope, id string, portsPath string) {
+	ok := forEachMember(ctx, client, s, "port", portsPath, nil, func(_ int, _ string, port *PortResponse) {
+		if port.PortProtocol != "NVLink" {
+			return
+		}
+
+		mc.NewGPUNVLinkUp(s, id, port)
+		mc.NewGPUNVLinkSpeedGbps(s, id, port)
+
+		if port.Metrics.OdataId != "" {
+			portMetrics := PortMetrics{}
+			ok := client.redfish.Get(ctx, port.Metrics.OdataId, &portMetrics)
+			if ok {
+				mc.NewGPUNVLinkErrorCounts(s, id, port.Id, &portMetrics)
+			} else {
+				s.fail(id, "port_metrics")
+			}
+		}
+	})
+	if !ok {
+		s.fail(id, "ports")
+	}
+}
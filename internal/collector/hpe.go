@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"context"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+)
+
+// iLO names its GPU sensors "<number>-GPU <n>" and "<number>-GPU <n> Memory"
+var HPE_GPU_REGEXP = regexp.MustCompile(`^\d+-GPU ?(\d+)( Memory)?$`)
+
+func (client *Client) refreshHpeGPUs(ctx context.Context, mc *Collector, s *scope, sys *systemEndpoints) bool {
+	// Get GPU inventory and metrics, the processors and PCIe devices are not
+	// needed for the temperatures
+	if client.enabled(config.MetricsInventory, config.MetricsHealth, config.MetricsUtilization, config.MetricsPower) {
+		var count atomic.Int64
+		ok := forEachMember(ctx, client, s, "processor", sys.procPath, nil, func(_ int, c string, resp *GPU) {
+			if resp.ProcessorType != "GPU" {
+				return
+			}
+			count.Add(1)
+
+			gpuInfo := GPUInfo{}
+			gpuInfo.Id = resp.Id
+			gpuInfo.Manufacturer = resp.Manufacturer
+			gpuInfo.Model = resp.Model
+			gpuInfo.PartNumber = resp.PartNumber
+			gpuInfo.SerialNumber = resp.SerialNumber
+			gpuInfo.GPUGUID = resp.UUID
+			gpuInfo.Slot = resp.Location.PartLocation.LocationOrdinalValue
+
+			// iLO only reports the slot on the PCIe device of the GPU
+			if resp.Links.PCIeDevice.OdataId != "" && client.enabled(config.MetricsInventory) {
+				device := PCIeDeviceResponse{}
+				if ok := client.redfish.Get(ctx, resp.Links.PCIeDevice.OdataId, &device); ok {
+					if gpuInfo.Slot == 0 {
+						gpuInfo.Slot = device.Slot.Location.PartLocation.LocationOrdinalValue
+					}
+					if gpuInfo.SerialNumber == "" {
+						gpuInfo.SerialNumber = device.SerialNumber
+					}
+				} else {
+					s.fail(resp.Id, "pcie_device")
+				}
+			}
+
+			mc.NewGPUInfo(s, &gpuInfo)
+			mc.NewProcessorGPUHealth(s, resp)
+			mc.NewProcessorGPUState(s, resp)
+
+			if resp.Metrics.OdataId != "" && client.enabled(config.MetricsUtilization, config.MetricsPower) {
+				gpuMetrics := GPUMetrics{}
+				ok := client.redfish.Get(ctx, resp.Metrics.OdataId, &gpuMetrics)
+				if !ok {
+					s.fail(resp.Id, "processor_metrics")
+					return
+				}
+
+				// The Id of the metrics resource is not the Id of the GPU on iLO
+				gpuMetrics.Id = resp.Id
+
+				mc.NewGPUBandwidthPercent(s, &gpuMetrics)
+				mc.NewGPUConsumedPowerWatt(s, &gpuMetrics)
+				mc.NewGPUOperatingSpeedMHz(s, &gpuMetrics)
+			}
+		})
+		if !ok {
+			return false
+		}
+
+		// Older iLO firmware does not list the GPUs as processors, only as PCIe
+		// devices of the chassis that are marked as GPU in their Oem.Hpe section
+		if count.Load() == 0 && sys.devicesPath != "" {
+			ok = forEachMember(ctx, client, s, "pcie_device", sys.devicesPath, nil, func(_ int, c string, resp *PCIeDeviceResponse) {
+				if resp.Oem == nil || resp.Oem.Hpe == nil || resp.Oem.Hpe.DeviceType != "GPU" {
+					return
+				}
+				count.Add(1)
+
+				gpuInfo := GPUInfo{}
+				gpuInfo.Id = resp.ID
+				gpuInfo.Manufacturer = resp.Manufacturer
+				gpuInfo.Model = resp.Model
+				gpuInfo.PartNumber = resp.PartNumber
+				gpuInfo.SerialNumber = resp.SerialNumber
+				gpuInfo.Slot = resp.Slot.Location.PartLocation.LocationOrdinalValue
+
+				mc.NewGPUInfo(s, &gpuInfo)
+				mc.NewPCIeDeviceGPUHealth(s, resp)
+				mc.NewPCIeDeviceGPUState(s, resp)
+			})
+			if !ok {
+				return false
+			}
+		}
+
+		mc.NewGPUCount(s, int(count.Load()))
+	}
+
+	thermalResp := ThermalResponse{}
+	ok := client.enabled(config.MetricsThermal) && client.redfish.Get(ctx, sys.thermalPath, &thermalResp)
+	if !ok && client.enabled(config.MetricsThermal) {
+		s.fail(sys.id, "thermal")
+	}
+
+	if ok {
+		for _, t := range thermalResp.Temperatures {
+			matches := HPE_GPU_REGEXP.FindStringSubmatch(t.Name)
+			if matches == nil || t.Status.State != StateEnabled {
+				continue
+			}
+
+			id := "GPU" + matches[1]
+			if matches[2] != "" {
+				mc.NewGPUMemoryTemperatureCelsius(s, id, t.ReadingCelsius)
+			} else {
+				mc.NewGPUTemperatureCelsius(s, id, t.ReadingCelsius)
+			}
+		}
+	}
+
+	return true
+}
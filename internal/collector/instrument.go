@@ -0,0 +1,239 @@
+package collector
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	gocollectors "github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Metrics about the exporter itself, which are not tied to a single scrape.
+// They are created when the configuration is loaded and anew when a reload
+// changes the metrics prefix.
+var exporterMutex sync.RWMutex
+var exporterPrefix string
+var exporterRegistry *prometheus.Registry
+var redfishRequestDuration *prometheus.HistogramVec
+var redfishResponses *prometheus.CounterVec
+var redfishRetries *prometheus.CounterVec
+
+// Number of Redfish sessions that were created and not deleted yet
+var openSessions atomic.Int64
+
+// Number of requests for targets that are not allowed
+var rejectedTargets atomic.Uint64
+
+// Outcome of the last configuration reload
+var lastReloadSuccessful atomic.Bool
+var lastReloadSuccess atomic.Int64
+
+// Redfish resources that requests are classified by, the class of a request
+// is the last of these in its path
+var resourceClasses = map[string]bool{
+	"Chassis":            true,
+	"DellGPUSensors":     true,
+	"DellVideo":          true,
+	"EnvironmentMetrics": true,
+	"MemoryMetrics":      true,
+	"Metrics":            true,
+	"PCIeDevices":        true,
+	"Ports":              true,
+	"Power":              true,
+	"ProcessorMetrics":   true,
+	"Processors":         true,
+	"Sensors":            true,
+	"SessionService":     true,
+	"Sessions":           true,
+	"Systems":            true,
+	"Thermal":            true,
+}
+
+func initExporterMetrics(prefix string) {
+	redfishRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    prometheus.BuildFQName(prefix, "gpu_exporter", "redfish_request_duration_seconds"),
+			Help:    "Duration of Redfish requests by target and resource class in seconds",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"target", "resource"},
+	)
+	redfishResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(prefix, "gpu_exporter", "redfish_responses_total"),
+			Help: "Total number of Redfish responses by target, resource class and status code",
+		},
+		[]string{"target", "resource", "code"},
+	)
+	redfishRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(prefix, "gpu_exporter", "redfish_retries_total"),
+			Help: "Total number of retried Redfish requests by target",
+		},
+		[]string{"target"},
+	)
+	reloadSuccessful := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(prefix, "gpu_exporter", "config_last_reload_successful"),
+			Help: "Whether the last configuration reload was successful",
+		},
+		func() float64 {
+			if lastReloadSuccessful.Load() {
+				return 1
+			}
+			return 0
+		},
+	)
+	reloadSuccess := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(prefix, "gpu_exporter", "config_last_reload_success_timestamp_seconds"),
+			Help: "Timestamp of the last successful configuration reload",
+		},
+		func() float64 {
+			return float64(lastReloadSuccess.Load())
+		},
+	)
+
+	activeCollectors := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(prefix, "gpu_exporter", "collectors"),
+			Help: "Number of targets with a collector, which are evicted when idle",
+		},
+		func() float64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return float64(len(collectors))
+		},
+	)
+	sessions := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName(prefix, "gpu_exporter", "redfish_sessions"),
+			Help: "Number of open Redfish sessions",
+		},
+		func() float64 {
+			return float64(openSessions.Load())
+		},
+	)
+
+	rejected := prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(prefix, "gpu_exporter", "rejected_targets_total"),
+			Help: "Total number of requests rejected as their target is not allowed",
+		},
+		func() float64 {
+			return float64(rejectedTargets.Load())
+		},
+	)
+
+	exporterPrefix = prefix
+	exporterRegistry = prometheus.NewRegistry()
+	exporterRegistry.MustRegister(
+		redfishRequestDuration,
+		redfishResponses,
+		redfishRetries,
+		reloadSuccessful,
+		reloadSuccess,
+		activeCollectors,
+		sessions,
+		rejected,
+		gocollectors.NewGoCollector(),
+		gocollectors.NewProcessCollector(gocollectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Creates the metrics about the exporter with the metrics prefix of the
+// current configuration, unless they exist with it already. Their counters
+// start over if the prefix changed, as the metrics are different ones then.
+func UpdateExporterMetrics() {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+
+	prefix := config.Current().MetricsPrefix
+	if exporterRegistry == nil || exporterPrefix != prefix {
+		initExporterMetrics(prefix)
+	}
+}
+
+// Returns the registry of the metrics about the exporter itself
+func ExporterRegistry() *prometheus.Registry {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+
+	return exporterRegistry
+}
+
+// Records the outcome of a configuration reload, including the initial load
+func RecordReload(ok bool) {
+	lastReloadSuccessful.Store(ok)
+	if ok {
+		lastReloadSuccess.Store(time.Now().Unix())
+	}
+}
+
+// Records a request for a target that is not allowed
+func RecordRejectedTarget() {
+	rejectedTargets.Add(1)
+}
+
+// Records a Redfish request, a code of 0 means that no response was received
+func observeRequest(target string, path string, code int, duration time.Duration) {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+
+	resource := resourceClass(path)
+	status := "error"
+	if code > 0 {
+		status = strconv.Itoa(code)
+	}
+
+	redfishRequestDuration.WithLabelValues(target, resource).Observe(duration.Seconds())
+	redfishResponses.WithLabelValues(target, resource, status).Inc()
+}
+
+// Records the retry of a Redfish request
+func observeRetry(target string) {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+
+	redfishRetries.WithLabelValues(target).Inc()
+}
+
+// Deletes the series of the Redfish requests of a target, so that the metrics
+// do not grow with every target that was ever scraped
+func deleteTargetMetrics(target string) {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+
+	labels := prometheus.Labels{"target": target}
+	redfishRequestDuration.DeletePartialMatch(labels)
+	redfishResponses.DeletePartialMatch(labels)
+	redfishRetries.DeletePartialMatch(labels)
+}
+
+// Classifies the path of a request by the resource it refers to, so that the
+// resources of all systems, chassis and GPUs of a class are counted together
+func resourceClass(path string) string {
+	path = strings.TrimSuffix(strings.TrimPrefix(path, redfishRootPath), "/")
+	if path == "" {
+		return "ServiceRoot"
+	}
+
+	segments := strings.Split(path, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if !resourceClasses[segments[i]] {
+			continue
+		}
+
+		// The metrics of ports are only called Metrics
+		if segments[i] == "Metrics" {
+			return "PortMetrics"
+		}
+		return segments[i]
+	}
+
+	return "Other"
+}
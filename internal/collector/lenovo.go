@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+)
+
+func (client *Client) refreshLenovoGPUs(ctx context.Context, mc *Collector, s *scope, sys *systemEndpoints) bool {
+	// Get GPU inventory and metrics
+	gpus := map[string]string{}
+	mutex := sync.Mutex{}
+	ok := forEachMember(ctx, client, s, "processor", sys.procPath, nil, func(_ int, c string, resp *GPU) {
+		if resp.ProcessorType != "GPU" {
+			return
+		}
+		mutex.Lock()
+		gpus[c] = resp.Id
+		mutex.Unlock()
+
+		gpuInfo := GPUInfo{}
+		gpuInfo.Id = resp.Id
+		gpuInfo.Manufacturer = resp.Manufacturer
+		gpuInfo.Model = resp.Model
+		gpuInfo.PartNumber = resp.PartNumber
+		gpuInfo.SerialNumber = resp.SerialNumber
+		gpuInfo.GPUGUID = resp.UUID
+		gpuInfo.Slot = resp.Location.PartLocation.LocationOrdinalValue
+
+		mc.NewGPUInfo(s, &gpuInfo)
+		mc.NewProcessorGPUHealth(s, resp)
+		mc.NewProcessorGPUState(s, resp)
+
+		speed := false
+		if resp.Metrics.OdataId != "" && client.enabled(config.MetricsUtilization) {
+			gpuMetrics := GPUMetrics{}
+			ok := client.redfish.Get(ctx, resp.Metrics.OdataId, &gpuMetrics)
+			if ok {
+				// The Id of the metrics resource is not the Id of the GPU on XCC
+				gpuMetrics.Id = resp.Id
+
+				mc.NewGPUBandwidthPercent(s, &gpuMetrics)
+				mc.NewGPUOperatingSpeedMHz(s, &gpuMetrics)
+				speed = gpuMetrics.OperatingSpeedMHz != nil
+			} else {
+				s.fail(resp.Id, "processor_metrics")
+			}
+		}
+
+		// Older XCC firmware only reports the clock speed in the OEM section
+		if !speed && resp.Oem.Lenovo != nil && resp.Oem.Lenovo.CurrentClockSpeedMHz != nil {
+			mc.NewGPUOperatingSpeed(s, resp.Id, *resp.Oem.Lenovo.CurrentClockSpeedMHz)
+		}
+	})
+	if !ok {
+		return false
+	}
+
+	mc.NewGPUCount(s, len(gpus))
+
+	// Power and temperatures are only available as sensors
+	client.refreshGPUSensors(ctx, mc, s, sys, gpus, map[string]bool{})
+
+	return true
+}
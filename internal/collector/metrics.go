@@ -3,10 +3,45 @@ package collector
 import (
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Destination of the metrics of a system, which labels every series with the
+// system and the chassis they were collected from and drops those that are
+// not selected
+type scope struct {
+	ch       chan<- prometheus.Metric
+	system   string
+	chassis  string
+	disabled map[*prometheus.Desc]bool
+
+	// Number of failed requests by the id of the GPU and the kind of resource
+	mutex  sync.Mutex
+	failed map[[2]string]int
+}
+
+// Records that a resource of the GPU with the given id could not be fetched,
+// the collection continues with the remaining resources
+func (s *scope) fail(id string, resource string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failed == nil {
+		s.failed = map[[2]string]int{}
+	}
+	s.failed[[2]string{id, resource}]++
+}
+
+func (s *scope) send(desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labels ...string) {
+	if s.disabled[desc] {
+		return
+	}
+	labels = append(labels, s.system, s.chassis)
+	s.ch <- prometheus.MustNewConstMetric(desc, valueType, value, labels...)
+}
+
 func gpuHealth2value(gpuHealth string) int {
 	switch strings.ToLower(gpuHealth) {
 	case "critical":
@@ -74,16 +109,16 @@ func thermalAlertStatus2value(thermalAlertStatus string) (bool, int) {
 	}
 }
 
-func (mc *Collector) NewGPUCount(ch chan<- prometheus.Metric, count int) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUCount(s *scope, count int) {
+	s.send(
 		mc.GPUCount,
 		prometheus.CounterValue,
 		float64(count),
 	)
 }
 
-func (mc *Collector) NewGPUInfo(ch chan<- prometheus.Metric, m *GPUInfo) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUInfo(s *scope, m *GPUInfo) {
+	s.send(
 		mc.GPUInfo,
 		prometheus.UntypedValue,
 		1.0,
@@ -97,9 +132,9 @@ func (mc *Collector) NewGPUInfo(ch chan<- prometheus.Metric, m *GPUInfo) {
 	)
 }
 
-func (mc *Collector) NewDellGPUState(ch chan<- prometheus.Metric, m *DellVideoMember) {
+func (mc *Collector) NewDellGPUState(s *scope, m *DellVideoMember) {
 	value := gpuState2value(m.GPUState)
-	ch <- prometheus.MustNewConstMetric(
+	s.send(
 		mc.GPUState,
 		prometheus.GaugeValue,
 		float64(value),
@@ -108,9 +143,9 @@ func (mc *Collector) NewDellGPUState(ch chan<- prometheus.Metric, m *DellVideoMe
 	)
 }
 
-func (mc *Collector) NewDellGPUHealth(ch chan<- prometheus.Metric, m *DellVideoMember) {
+func (mc *Collector) NewDellGPUHealth(s *scope, m *DellVideoMember) {
 	value := gpuHealth2value(m.GPUHealth)
-	ch <- prometheus.MustNewConstMetric(
+	s.send(
 		mc.GPUHealth,
 		prometheus.GaugeValue,
 		float64(value),
@@ -119,9 +154,9 @@ func (mc *Collector) NewDellGPUHealth(ch chan<- prometheus.Metric, m *DellVideoM
 	)
 }
 
-func (mc *Collector) NewSupermicroGPUHealth(ch chan<- prometheus.Metric, m *PCIeDeviceResponse) {
+func (mc *Collector) NewPCIeDeviceGPUHealth(s *scope, m *PCIeDeviceResponse) {
 	value := gpuHealth2value(m.Status.Health)
-	ch <- prometheus.MustNewConstMetric(
+	s.send(
 		mc.GPUHealth,
 		prometheus.GaugeValue,
 		float64(value),
@@ -130,9 +165,9 @@ func (mc *Collector) NewSupermicroGPUHealth(ch chan<- prometheus.Metric, m *PCIe
 	)
 }
 
-func (mc *Collector) NewSupermicroGPUState(ch chan<- prometheus.Metric, m *PCIeDeviceResponse) {
+func (mc *Collector) NewPCIeDeviceGPUState(s *scope, m *PCIeDeviceResponse) {
 	value := gpuState2value(m.Status.State)
-	ch <- prometheus.MustNewConstMetric(
+	s.send(
 		mc.GPUState,
 		prometheus.GaugeValue,
 		float64(value),
@@ -141,8 +176,30 @@ func (mc *Collector) NewSupermicroGPUState(ch chan<- prometheus.Metric, m *PCIeD
 	)
 }
 
-func (mc *Collector) NewSmcGPUTemp(ch chan<- prometheus.Metric, id string, value float64) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewProcessorGPUHealth(s *scope, m *GPU) {
+	value := gpuHealth2value(m.Status.Health)
+	s.send(
+		mc.GPUHealth,
+		prometheus.GaugeValue,
+		float64(value),
+		m.Id,
+		m.Status.Health,
+	)
+}
+
+func (mc *Collector) NewProcessorGPUState(s *scope, m *GPU) {
+	value := gpuState2value(m.Status.State)
+	s.send(
+		mc.GPUState,
+		prometheus.GaugeValue,
+		float64(value),
+		m.Id,
+		m.Status.State,
+	)
+}
+
+func (mc *Collector) NewGPUTemperatureCelsius(s *scope, id string, value float64) {
+	s.send(
 		mc.GPUPrimaryGPUTemperatureCelsius,
 		prometheus.GaugeValue,
 		value,
@@ -150,8 +207,8 @@ func (mc *Collector) NewSmcGPUTemp(ch chan<- prometheus.Metric, id string, value
 	)
 }
 
-func (mc *Collector) NewSmcGPUMemoryTemp(ch chan<- prometheus.Metric, id string, value float64) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUMemoryTemperatureCelsius(s *scope, id string, value float64) {
+	s.send(
 		mc.GPUMemoryTemperatureCelsius,
 		prometheus.GaugeValue,
 		value,
@@ -159,9 +216,9 @@ func (mc *Collector) NewSmcGPUMemoryTemp(ch chan<- prometheus.Metric, id string,
 	)
 }
 
-func (mc *Collector) NewBoardPowerSupplyStatus(ch chan<- prometheus.Metric, m *DellGPUSensorMember) {
+func (mc *Collector) NewBoardPowerSupplyStatus(s *scope, m *DellGPUSensorMember) {
 	if ok, value := boardPowerSupplyStatus2value(m.BoardPowerSupplyStatus); ok {
-		ch <- prometheus.MustNewConstMetric(
+		s.send(
 			mc.GPUBoardPowerSupplyStatus,
 			prometheus.GaugeValue,
 			float64(value),
@@ -171,8 +228,8 @@ func (mc *Collector) NewBoardPowerSupplyStatus(ch chan<- prometheus.Metric, m *D
 	}
 }
 
-func (mc *Collector) NewMemoryTemperatureCelsius(ch chan<- prometheus.Metric, m *DellGPUSensorMember) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewMemoryTemperatureCelsius(s *scope, m *DellGPUSensorMember) {
+	s.send(
 		mc.GPUMemoryTemperatureCelsius,
 		prometheus.GaugeValue,
 		m.MemoryTemperatureCelsius,
@@ -180,9 +237,9 @@ func (mc *Collector) NewMemoryTemperatureCelsius(ch chan<- prometheus.Metric, m
 	)
 }
 
-func (mc *Collector) NewPowerBrakeStatus(ch chan<- prometheus.Metric, m *DellGPUSensorMember) {
+func (mc *Collector) NewPowerBrakeStatus(s *scope, m *DellGPUSensorMember) {
 	if ok, value := powerBrakeStatus2value(m.PowerBrakeStatus); ok {
-		ch <- prometheus.MustNewConstMetric(
+		s.send(
 			mc.GPUPowerBrakeStatus,
 			prometheus.GaugeValue,
 			float64(value),
@@ -192,8 +249,8 @@ func (mc *Collector) NewPowerBrakeStatus(ch chan<- prometheus.Metric, m *DellGPU
 	}
 }
 
-func (mc *Collector) NewPrimaryGPUTemperatureCelsius(ch chan<- prometheus.Metric, m *DellGPUSensorMember) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewPrimaryGPUTemperatureCelsius(s *scope, m *DellGPUSensorMember) {
+	s.send(
 		mc.GPUPrimaryGPUTemperatureCelsius,
 		prometheus.GaugeValue,
 		m.PrimaryGPUTemperatureCelsius,
@@ -201,9 +258,9 @@ func (mc *Collector) NewPrimaryGPUTemperatureCelsius(ch chan<- prometheus.Metric
 	)
 }
 
-func (mc *Collector) NewThermalAlertStatus(ch chan<- prometheus.Metric, m *DellGPUSensorMember) {
+func (mc *Collector) NewThermalAlertStatus(s *scope, m *DellGPUSensorMember) {
 	if ok, value := thermalAlertStatus2value(m.ThermalAlertStatus); ok {
-		ch <- prometheus.MustNewConstMetric(
+		s.send(
 			mc.GPUThermalAlertStatus,
 			prometheus.GaugeValue,
 			float64(value),
@@ -213,11 +270,11 @@ func (mc *Collector) NewThermalAlertStatus(ch chan<- prometheus.Metric, m *DellG
 	}
 }
 
-func (mc *Collector) NewGPUOperatingSpeedMHz(ch chan<- prometheus.Metric, m *GPUMetrics) {
+func (mc *Collector) NewGPUOperatingSpeedMHz(s *scope, m *GPUMetrics) {
 	if m.OperatingSpeedMHz == nil {
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(
+	s.send(
 		mc.GPUOperatingSpeedMHz,
 		prometheus.GaugeValue,
 		*m.OperatingSpeedMHz,
@@ -225,10 +282,10 @@ func (mc *Collector) NewGPUOperatingSpeedMHz(ch chan<- prometheus.Metric, m *GPU
 	)
 }
 
-func (mc *Collector) NewGPUThrottleReasons(ch chan<- prometheus.Metric, v []string, id string) {
+func (mc *Collector) NewGPUThrottleReasons(s *scope, v []string, id string) {
 	for _, reason := range v {
 		// TODO: default all possible reason metrics to zero when known
-		ch <- prometheus.MustNewConstMetric(
+		s.send(
 			mc.GPUThrottleReason,
 			prometheus.GaugeValue,
 			1.0,
@@ -238,8 +295,8 @@ func (mc *Collector) NewGPUThrottleReasons(ch chan<- prometheus.Metric, v []stri
 	}
 }
 
-func (mc *Collector) NewGPUSMUtilizationPercent(ch chan<- prometheus.Metric, v int, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUSMUtilizationPercent(s *scope, v int, id string) {
+	s.send(
 		mc.GPUSMUtilizationPercent,
 		prometheus.GaugeValue,
 		float64(v),
@@ -247,8 +304,8 @@ func (mc *Collector) NewGPUSMUtilizationPercent(ch chan<- prometheus.Metric, v i
 	)
 }
 
-func (mc *Collector) NewGPUSMActivityPercent(ch chan<- prometheus.Metric, v float64, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUSMActivityPercent(s *scope, v float64, id string) {
+	s.send(
 		mc.GPUSMActivityPercent,
 		prometheus.GaugeValue,
 		v,
@@ -256,8 +313,8 @@ func (mc *Collector) NewGPUSMActivityPercent(ch chan<- prometheus.Metric, v floa
 	)
 }
 
-func (mc *Collector) NewGPUSMOccupancyPercent(ch chan<- prometheus.Metric, v float64, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUSMOccupancyPercent(s *scope, v float64, id string) {
+	s.send(
 		mc.GPUSMOccupancyPercent,
 		prometheus.GaugeValue,
 		v,
@@ -265,8 +322,8 @@ func (mc *Collector) NewGPUSMOccupancyPercent(ch chan<- prometheus.Metric, v flo
 	)
 }
 
-func (mc *Collector) NewGPUTensorCoreActivityPercent(ch chan<- prometheus.Metric, v float64, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUTensorCoreActivityPercent(s *scope, v float64, id string) {
+	s.send(
 		mc.GPUTensorCoreActivityPercent,
 		prometheus.GaugeValue,
 		v,
@@ -274,8 +331,8 @@ func (mc *Collector) NewGPUTensorCoreActivityPercent(ch chan<- prometheus.Metric
 	)
 }
 
-func (mc *Collector) NewGPUHMMAUtilizationPercent(ch chan<- prometheus.Metric, v float64, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUHMMAUtilizationPercent(s *scope, v float64, id string) {
+	s.send(
 		mc.GPUHMMAUtilizationPercent,
 		prometheus.GaugeValue,
 		v,
@@ -283,8 +340,8 @@ func (mc *Collector) NewGPUHMMAUtilizationPercent(ch chan<- prometheus.Metric, v
 	)
 }
 
-func (mc *Collector) NewGPUPCIeRawTxBandwidthGbps(ch chan<- prometheus.Metric, v float64, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUPCIeRawTxBandwidthGbps(s *scope, v float64, id string) {
+	s.send(
 		mc.GPUPCIeRawTxBandwidthGbps,
 		prometheus.GaugeValue,
 		v,
@@ -292,8 +349,8 @@ func (mc *Collector) NewGPUPCIeRawTxBandwidthGbps(ch chan<- prometheus.Metric, v
 	)
 }
 
-func (mc *Collector) NewGPUPCIeRawRxBandwidthGbps(ch chan<- prometheus.Metric, v float64, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUPCIeRawRxBandwidthGbps(s *scope, v float64, id string) {
+	s.send(
 		mc.GPUPCIeRawRxBandwidthGbps,
 		prometheus.GaugeValue,
 		v,
@@ -301,8 +358,8 @@ func (mc *Collector) NewGPUPCIeRawRxBandwidthGbps(ch chan<- prometheus.Metric, v
 	)
 }
 
-func (mc *Collector) NewGPUCurrentPCIeLinkSpeed(ch chan<- prometheus.Metric, v int, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUCurrentPCIeLinkSpeed(s *scope, v int, id string) {
+	s.send(
 		mc.GPUCurrentPCIeLinkSpeed,
 		prometheus.GaugeValue,
 		float64(v),
@@ -310,8 +367,8 @@ func (mc *Collector) NewGPUCurrentPCIeLinkSpeed(ch chan<- prometheus.Metric, v i
 	)
 }
 
-func (mc *Collector) NewGPUMaxSupportedPCIeLinkSpeed(ch chan<- prometheus.Metric, v int, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUMaxSupportedPCIeLinkSpeed(s *scope, v int, id string) {
+	s.send(
 		mc.GPUMaxSupportedPCIeLinkSpeed,
 		prometheus.GaugeValue,
 		float64(v),
@@ -319,8 +376,8 @@ func (mc *Collector) NewGPUMaxSupportedPCIeLinkSpeed(ch chan<- prometheus.Metric
 	)
 }
 
-func (mc *Collector) NewGPUDRAMUtilizationPercent(ch chan<- prometheus.Metric, v float64, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUDRAMUtilizationPercent(s *scope, v float64, id string) {
+	s.send(
 		mc.GPUDRAMUtilizationPercent,
 		prometheus.GaugeValue,
 		v,
@@ -328,8 +385,8 @@ func (mc *Collector) NewGPUDRAMUtilizationPercent(ch chan<- prometheus.Metric, v
 	)
 }
 
-func (mc *Collector) NewGPUPCIeCorrectableErrorCount(ch chan<- prometheus.Metric, v int, id string) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUPCIeCorrectableErrorCount(s *scope, v int, id string) {
+	s.send(
 		mc.GPUPCIeCorrectableErrorCount,
 		prometheus.CounterValue,
 		float64(v),
@@ -337,11 +394,11 @@ func (mc *Collector) NewGPUPCIeCorrectableErrorCount(ch chan<- prometheus.Metric
 	)
 }
 
-func (mc *Collector) NewGPUBandwidthPercent(ch chan<- prometheus.Metric, m *GPUMetrics) {
+func (mc *Collector) NewGPUBandwidthPercent(s *scope, m *GPUMetrics) {
 	if m.BandwidthPercent == nil {
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(
+	s.send(
 		mc.GPUBandwidthPercent,
 		prometheus.GaugeValue,
 		*m.BandwidthPercent,
@@ -349,8 +406,8 @@ func (mc *Collector) NewGPUBandwidthPercent(ch chan<- prometheus.Metric, m *GPUM
 	)
 }
 
-func (mc *Collector) NewGPUMemoryOperatingSpeedMHz(ch chan<- prometheus.Metric, id string, m *GPUMemoryMetrics) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUMemoryOperatingSpeedMHz(s *scope, id string, m *GPUMemoryMetrics) {
+	s.send(
 		mc.GPUMemoryOperatingSpeedMHz,
 		prometheus.GaugeValue,
 		m.OperatingSpeedMHz,
@@ -358,8 +415,8 @@ func (mc *Collector) NewGPUMemoryOperatingSpeedMHz(ch chan<- prometheus.Metric,
 	)
 }
 
-func (mc *Collector) NewGPUMemoryBandwidthPercent(ch chan<- prometheus.Metric, id string, m *GPUMemoryMetrics) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUMemoryBandwidthPercent(s *scope, id string, m *GPUMemoryMetrics) {
+	s.send(
 		mc.GPUMemoryBandwidthPercent,
 		prometheus.GaugeValue,
 		m.BandwidthPercent,
@@ -367,11 +424,145 @@ func (mc *Collector) NewGPUMemoryBandwidthPercent(ch chan<- prometheus.Metric, i
 	)
 }
 
-func (mc *Collector) NewGPUConsumedPowerWatt(ch chan<- prometheus.Metric, m *GPUMetrics) {
-	ch <- prometheus.MustNewConstMetric(
+func (mc *Collector) NewGPUConsumedPowerWatt(s *scope, m *GPUMetrics) {
+	s.send(
 		mc.GPUConsumedPowerWatt,
 		prometheus.GaugeValue,
 		m.ConsumedPowerWatt,
 		m.Id,
 	)
 }
+
+func (mc *Collector) NewGPUConsumedPower(s *scope, id string, value float64) {
+	s.send(
+		mc.GPUConsumedPowerWatt,
+		prometheus.GaugeValue,
+		value,
+		id,
+	)
+}
+
+func (mc *Collector) NewGPUOperatingSpeed(s *scope, id string, value float64) {
+	s.send(
+		mc.GPUOperatingSpeedMHz,
+		prometheus.GaugeValue,
+		value,
+		id,
+	)
+}
+
+func (mc *Collector) NewGPUNvidiaMetrics(s *scope, id string, m *GPUMetrics) {
+	if m.Oem == nil || m.Oem.Nvidia == nil {
+		return
+	}
+	nvidia := m.Oem.Nvidia
+	mc.NewGPUThrottleReasons(s, nvidia.ThrottleReasons, id)
+	mc.NewGPUSMUtilizationPercent(s, nvidia.SMUtilizationPercent, id)
+	mc.NewGPUSMActivityPercent(s, nvidia.SMActivityPercent, id)
+	mc.NewGPUSMOccupancyPercent(s, nvidia.SMOccupancyPercent, id)
+	mc.NewGPUTensorCoreActivityPercent(s, nvidia.TensorCoreActivityPercent, id)
+	mc.NewGPUHMMAUtilizationPercent(s, nvidia.HMMAUtilizationPercent, id)
+	mc.NewGPUPCIeRawTxBandwidthGbps(s, nvidia.PCIeRawTxBandwidthGbps, id)
+	mc.NewGPUPCIeRawRxBandwidthGbps(s, nvidia.PCIeRawRxBandwidthGbps, id)
+}
+
+func (mc *Collector) NewGPUMemoryECCErrors(s *scope, id string, m *GPUMemoryMetrics) {
+	if m.LifeTime == nil {
+		return
+	}
+	s.send(
+		mc.GPUMemoryCorrectableECCErrors,
+		prometheus.CounterValue,
+		float64(m.LifeTime.CorrectableECCErrorCount),
+		id,
+	)
+	s.send(
+		mc.GPUMemoryUncorrectableECCErrors,
+		prometheus.CounterValue,
+		float64(m.LifeTime.UncorrectableECCErrorCount),
+		id,
+	)
+}
+
+func (mc *Collector) NewGPUNVLinkUp(s *scope, id string, m *PortResponse) {
+	value := 0.0
+	if m.LinkStatus == "LinkUp" {
+		value = 1.0
+	}
+	s.send(
+		mc.GPUNVLinkUp,
+		prometheus.GaugeValue,
+		value,
+		id,
+		m.Id,
+	)
+}
+
+func (mc *Collector) NewGPUNVLinkSpeedGbps(s *scope, id string, m *PortResponse) {
+	if m.CurrentSpeedGbps == nil {
+		return
+	}
+	s.send(
+		mc.GPUNVLinkSpeedGbps,
+		prometheus.GaugeValue,
+		*m.CurrentSpeedGbps,
+		id,
+		m.Id,
+	)
+}
+
+func (mc *Collector) NewGPUNVLinkErrorCounts(s *scope, id string, port string, m *PortMetrics) {
+	if m.Oem == nil || m.Oem.Nvidia == nil {
+		return
+	}
+	errors := map[string]int{
+		"link_error_recovery": m.Oem.Nvidia.LinkErrorRecoveryCount,
+		"link_downed":         m.Oem.Nvidia.LinkDownedCount,
+		"symbol":              m.Oem.Nvidia.SymbolErrors,
+	}
+	for t, v := range errors {
+		s.send(
+			mc.GPUNVLinkErrorCount,
+			prometheus.CounterValue,
+			float64(v),
+			id,
+			port,
+			t,
+		)
+	}
+}
+
+func (mc *Collector) NewNVSwitchHealth(s *scope, m *ChassisResponse) {
+	value := gpuHealth2value(m.Status.Health)
+	s.send(
+		mc.NVSwitchHealth,
+		prometheus.GaugeValue,
+		float64(value),
+		m.Id,
+		m.Status.Health,
+	)
+}
+
+func (mc *Collector) NewNVSwitchTemperatureCelsius(s *scope, id string, value float64) {
+	s.send(
+		mc.NVSwitchTemperatureCelsius,
+		prometheus.GaugeValue,
+		value,
+		id,
+	)
+}
+
+func (mc *Collector) NewCollectionErrors(s *scope) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for k, count := range s.failed {
+		s.send(
+			mc.GPUCollectionErrors,
+			prometheus.GaugeValue,
+			float64(count),
+			k[0],
+			k[1],
+		)
+	}
+}
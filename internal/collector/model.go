@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"encoding/json"
 	"strconv"
 )
 
@@ -83,6 +84,16 @@ type V1Response struct {
 	Tasks              Odata  `json:"Tasks"`
 	TelemetryService   Odata  `json:"TelemetryService"`
 	UpdateService      Odata  `json:"UpdateService"`
+
+	ProtocolFeaturesSupported struct {
+		ExpandQuery *struct {
+			ExpandAll bool `json:"ExpandAll"`
+			Levels    bool `json:"Levels"`
+			Links     bool `json:"Links"`
+			MaxLevels int  `json:"MaxLevels"`
+			NoLinks   bool `json:"NoLinks"`
+		} `json:"ExpandQuery"`
+	} `json:"ProtocolFeaturesSupported"`
 }
 
 type GroupResponse struct {
@@ -91,6 +102,12 @@ type GroupResponse struct {
 	Members     OdataSlice `json:"Members"`
 }
 
+// ExpandedGroupResponse keeps the members of a collection requested with
+// $expand, which can either be links or complete resources
+type ExpandedGroupResponse struct {
+	Members []json.RawMessage `json:"Members"`
+}
+
 type Processor struct {
 	Id                    string  `json:"Id"`
 	Name                  string  `json:"Name"`
@@ -136,6 +153,13 @@ type Processor struct {
 	} `json:"Oem"`
 }
 
+// PartLocation is a common structure describing where a part is located
+type PartLocation struct {
+	LocationOrdinalValue int    `json:"LocationOrdinalValue"`
+	LocationType         string `json:"LocationType"`
+	ServiceLabel         string `json:"ServiceLabel"`
+}
+
 type GPU struct {
 	Id            string `json:"Id"`
 	Name          string `json:"Name"`
@@ -143,12 +167,28 @@ type GPU struct {
 	Manufacturer  string `json:"Manufacturer"`
 	Model         string `json:"Model"`
 	PartNumber    string `json:"PartNumber"`
+	SerialNumber  string `json:"SerialNumber"`
+	UUID          string `json:"UUID"`
 	Metrics       Odata  `json:"Metrics"`
 	MemorySummary struct {
 		Metrics Odata `json:"Metrics"`
 	} `json:"MemorySummary"`
-	ProcessorType string `json:"ProcessorType"`
-	Status        Status `json:"Status"`
+	EnvironmentMetrics Odata  `json:"EnvironmentMetrics"`
+	Ports              Odata  `json:"Ports"`
+	ProcessorType      string `json:"ProcessorType"`
+	Status             Status `json:"Status"`
+	Location           struct {
+		PartLocation PartLocation `json:"PartLocation"`
+	} `json:"Location"`
+	Links struct {
+		Chassis    Odata `json:"Chassis"`
+		PCIeDevice Odata `json:"PCIeDevice"`
+	} `json:"Links"`
+	Oem struct {
+		Lenovo *struct {
+			CurrentClockSpeedMHz *float64 `json:"CurrentClockSpeedMHz"`
+		} `json:"Lenovo"`
+	} `json:"Oem"`
 }
 
 type DellVideoMember struct {
@@ -205,12 +245,50 @@ type GPUMetrics struct {
 }
  
 
+// SensorExcerpt is a common structure used to embed sensor readings
+type SensorExcerpt struct {
+	DataSourceUri string   `json:"DataSourceUri"`
+	Reading       *float64 `json:"Reading"`
+}
+
+type EnvironmentMetrics struct {
+	Id                 string         `json:"Id"`
+	TemperatureCelsius *SensorExcerpt `json:"TemperatureCelsius"`
+	PowerWatts         *SensorExcerpt `json:"PowerWatts"`
+}
+
 type GPUMemoryMetrics struct {
 	BandwidthPercent  float64 `json:"BandwidthPercent"`
 	OperatingSpeedMHz float64 `json:"OperatingSpeedMHz"`
+	LifeTime          *struct {
+		CorrectableECCErrorCount   int `json:"CorrectableECCErrorCount"`
+		UncorrectableECCErrorCount int `json:"UncorrectableECCErrorCount"`
+	} `json:"LifeTime"`
+}
+
+type PortResponse struct {
+	Id               string   `json:"Id"`
+	Name             string   `json:"Name"`
+	PortProtocol     string   `json:"PortProtocol"`
+	LinkStatus       string   `json:"LinkStatus"`
+	CurrentSpeedGbps *float64 `json:"CurrentSpeedGbps"`
+	Metrics          Odata    `json:"Metrics"`
+	Status           Status   `json:"Status"`
+}
+
+type PortMetrics struct {
+	Id  string `json:"Id"`
+	Oem *struct {
+		Nvidia *struct {
+			LinkErrorRecoveryCount int `json:"LinkErrorRecoveryCount"`
+			LinkDownedCount        int `json:"LinkDownedCount"`
+			SymbolErrors           int `json:"SymbolErrors"`
+		} `json:"Nvidia"`
+	} `json:"Oem"`
 }
 
 type ChassisResponse struct {
+	Id                      string `json:"Id"`
 	Name                    string `json:"Name"`
 	AssetTag                string `json:"AssetTag"`
 	SerialNumber            string `json:"SerialNumber"`
@@ -225,6 +303,7 @@ type ChassisResponse struct {
 	IndicatorLED            string `json:"IndicatorLED"`
 	LocationIndicatorActive *bool  `json:"LocationIndicatorActive"`
 	Assembly                Odata  `json:"Assembly"`
+	EnvironmentMetrics      Odata  `json:"EnvironmentMetrics"`
 	Location                *struct {
 		Info       string `json:"Info"`
 		InfoFormat string `json:"InfoFormat"`
@@ -250,9 +329,13 @@ type ChassisResponse struct {
 		IntrusionSensorNumber int    `json:"IntrusionSensorNumber"`
 		IntrusionSensorReArm  string `json:"IntrusionSensorReArm"`
 	} `json:"PhysicalSecurity"`
+	Links struct {
+		ContainedBy Odata `json:"ContainedBy"`
+	} `json:"Links"`
 }
 
 type SystemResponse struct {
+	Id                      string `json:"Id"`
 	IndicatorLED            string `json:"IndicatorLED"`
 	LocationIndicatorActive *bool  `json:"LocationIndicatorActive"`
 	Manufacturer            string `json:"Manufacturer"`
@@ -310,6 +393,9 @@ type SystemResponse struct {
 		InterfaceType   string `json:"InterfaceType"`
 		Status          Status `json:"Status"`
 	} `json:"TrustedModules"`
+	Links struct {
+		Chassis OdataSlice `json:"Chassis"`
+	} `json:"Links"`
 	Oem struct {
 		Hpe struct {
 			IndicatorLED string `json:"IndicatorLED"`
@@ -321,6 +407,7 @@ type PCIeDeviceResponse struct {
 	ID              string `json:"Id"`
 	Name            string `json:"Name"`
 	Description     string `json:"Description"`
+	Manufacturer    string `json:"Manufacturer"`
 	Model           string `json:"Model"`
 	SerialNumber    string `json:"SerialNumber"`
 	PartNumber      string `json:"PartNumber"`
@@ -334,6 +421,11 @@ type PCIeDeviceResponse struct {
 		MaxLanes    int    `json:"MaxLanes"`
 	} `json:"PCIeInterface"`
 	PCIeFunctions Odata `json:"PCIeFunctions"`
+	Slot          struct {
+		Location struct {
+			PartLocation PartLocation `json:"PartLocation"`
+		} `json:"Location"`
+	} `json:"Slot"`
 	Oem *struct {
 		Supermicro *struct {
 			OdataType        string `json:"@odata.type"`
@@ -347,6 +439,10 @@ type PCIeDeviceResponse struct {
 			InfoROMVersion   string `json:"InfoROMVersion"`
 			GPUVendor        string `json:"GPUVendor"`
 		} `json:"Supermicro"`
+		Hpe *struct {
+			DeviceType     string `json:"DeviceType"`
+			LocationString string `json:"LocationString"`
+		} `json:"Hpe"`
 	} `json:"Oem"`
 }
 
@@ -442,3 +538,14 @@ func (t *Temperature) GetId(fallback int) string {
 	}
 	return strconv.Itoa(fallback)
 }
+
+type SensorResponse struct {
+	Id              string   `json:"Id"`
+	Name            string   `json:"Name"`
+	Reading         *float64 `json:"Reading"`
+	ReadingType     string   `json:"ReadingType"`
+	ReadingUnits    string   `json:"ReadingUnits"`
+	PhysicalContext string   `json:"PhysicalContext"`
+	Status          Status   `json:"Status"`
+	RelatedItem     []Odata  `json:"RelatedItem"`
+}
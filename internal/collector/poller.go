@@ -0,0 +1,189 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/config"
+	"github.com/firmus-public/oob_gpu_exporter/internal/log"
+)
+
+var pollersMutex sync.Mutex
+var pollers = map[string]*Poller{}
+
+// A Poller collects the metrics of a target in the background and keeps the
+// last good snapshot, so that scrapes do not have to wait for the BMC
+type Poller struct {
+	target   string
+	interval time.Duration
+
+	// Cancelled to stop polling including an ongoing poll, done is closed
+	// once the poller stopped
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex    sync.Mutex
+	snapshot string
+	updated  time.Time
+}
+
+func NewPoller(target string, interval time.Duration) *Poller {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Poller{
+		target:   target,
+		interval: interval,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Starts polling once the given poller that was replaced, if any, stopped, as
+// a poll would otherwise join its cancelled collection and fail
+func (p *Poller) Start(previous *Poller) {
+	log.Info("Polling host %s every %v", p.target, p.interval)
+
+	go func() {
+		defer close(p.done)
+
+		if previous != nil {
+			select {
+			case <-previous.done:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			p.poll()
+
+			select {
+			case <-ticker.C:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stops polling and cancels an ongoing poll without waiting for it
+func (p *Poller) Stop() {
+	log.Info("Stopped polling host %s", p.target)
+	p.cancel()
+}
+
+// Waits until the poller stopped after Stop
+func (p *Poller) Wait() {
+	<-p.done
+}
+
+func (p *Poller) poll() {
+	start := time.Now()
+
+	// A collection must not run into the next one
+	ctx, cancel := context.WithTimeout(p.ctx, p.interval)
+	defer cancel()
+
+	c, metrics, err := GatherTarget(ctx, p.target)
+	if err != nil {
+		log.Error("Error collecting metrics for host %s: %v", p.target, err)
+		return
+	}
+
+	// Keep the previous snapshot if the collection was not successful
+	if c.LastSuccess().Before(start) {
+		log.Error("Failed to poll host %s, keeping previous snapshot", p.target)
+		return
+	}
+
+	p.mutex.Lock()
+	p.snapshot = metrics
+	p.updated = time.Now()
+	p.mutex.Unlock()
+
+	log.Debug("Polled host %s in %v", p.target, time.Since(start))
+}
+
+// Returns the last snapshot unless it is older than the maximum staleness, in
+// which case the snapshot is dropped
+func (p *Poller) Snapshot() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	maxStaleness := time.Duration(config.Current().MaxStaleness) * time.Second
+	if p.snapshot != "" && time.Since(p.updated) > maxStaleness {
+		log.Error("Dropping snapshot of host %s last updated at %v", p.target, p.updated)
+		p.snapshot = ""
+	}
+
+	return p.snapshot
+}
+
+// Returns the snapshot of the given target and whether the target is polled
+// in the background at all
+func GetSnapshot(target string) (string, bool) {
+	pollersMutex.Lock()
+	p, ok := pollers[target]
+	pollersMutex.Unlock()
+
+	if !ok {
+		return "", false
+	}
+
+	return p.Snapshot(), true
+}
+
+// Starts and stops pollers to match the poll intervals of the configured hosts
+func UpdatePollers() {
+	intervals := map[string]time.Duration{}
+
+	for k, v := range config.Current().Hosts {
+		if k != "default" && v.PollInterval > 0 {
+			intervals[k] = time.Duration(v.PollInterval) * time.Second
+		}
+	}
+
+	pollersMutex.Lock()
+	defer pollersMutex.Unlock()
+
+	stopped := map[string]*Poller{}
+	for target, p := range pollers {
+		interval, ok := intervals[target]
+		if !ok || interval != p.interval {
+			p.Stop()
+			delete(pollers, target)
+			stopped[target] = p
+		}
+	}
+
+	for target, interval := range intervals {
+		if _, ok := pollers[target]; !ok {
+			p := NewPoller(target, interval)
+			pollers[target] = p
+			p.Start(stopped[target])
+		}
+	}
+}
+
+// Stops all pollers and waits for their ongoing polls, which is done before
+// the exporter exits so that no poll creates a collector afterwards. The lock
+// is not held while waiting, as polls take it to find the polled targets.
+func StopPollers() {
+	pollersMutex.Lock()
+	stopped := []*Poller{}
+	for target, p := range pollers {
+		p.Stop()
+		delete(pollers, target)
+		stopped = append(stopped, p)
+	}
+	pollersMutex.Unlock()
+
+	for _, p := range stopped {
+		p.Wait()
+	}
+}
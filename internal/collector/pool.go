@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	pathpkg "path"
+	"sync"
+
+	"github.com/firmus-public/oob_gpu_exporter/internal/log"
+)
+
+// Calls fn for each of the links from a pool of workers of the configured
+// concurrency of the host. The requests of nested pools share the slots of the
+// host, so at most that many resources are fetched at the same time. No more
+// links are handed out once the context is done.
+func (client *Client) forEach(ctx context.Context, links []string, fn func(i int, link string)) {
+	workers := client.concurrency
+	if workers > len(links) {
+		workers = len(links)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i, links[i])
+			}
+		}()
+	}
+
+dispatch:
+	for i := range links {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+}
+
+// Calls fn for each member of the collection at the given path whose link
+// passes the optional filter. If the service supports $expand, the collection
+// is fetched with its members in a single request, otherwise, if the expanded
+// request fails or for members that were not expanded one request is made per
+// member. Members that cannot be fetched are recorded as failed resources of
+// the given kind in the scope. Returns false if the collection could not be
+// fetched.
+func forEachMember[T any](ctx context.Context, client *Client, s *scope, resource string, path string, filter func(link string) bool, fn func(i int, link string, member *T)) bool {
+	links, expanded, ok := expandMembers(ctx, client, path, filter)
+	if !ok {
+		// Some services announce $expand but reject it for some collections,
+		// which are fetched without it unless the scrape already timed out
+		if ctx.Err() != nil {
+			return false
+		}
+
+		group := GroupResponse{}
+		if !client.redfish.Get(ctx, path, &group) {
+			return false
+		}
+
+		for _, link := range group.Members.GetLinks() {
+			if filter == nil || filter(link) {
+				links = append(links, link)
+			}
+		}
+	}
+
+	client.forEach(ctx, links, func(i int, link string) {
+		member := new(T)
+		if m, ok := expanded[link]; ok {
+			if err := json.Unmarshal(m, member); err != nil {
+				log.Error("Error decoding expanded member %q: %v", link, err)
+				s.fail(pathpkg.Base(link), resource)
+				return
+			}
+		} else if !client.redfish.Get(ctx, link, member) {
+			s.fail(pathpkg.Base(link), resource)
+			return
+		}
+
+		fn(i, link, member)
+	})
+
+	return true
+}
+
+// Returns the links of the members of the collection at the given path that
+// pass the optional filter and the members that were expanded, or false if
+// the service does not support $expand or the expanded request failed
+func expandMembers(ctx context.Context, client *Client, path string, filter func(link string) bool) ([]string, map[string]json.RawMessage, bool) {
+	if client.expand == "" {
+		return nil, nil, false
+	}
+
+	group := ExpandedGroupResponse{}
+	if !client.redfish.Get(ctx, path+"?"+client.expand, &group) {
+		log.Debug("Expanding %s failed, fetching its members one by one", path)
+		return nil, nil, false
+	}
+
+	links := []string{}
+	expanded := map[string]json.RawMessage{}
+	seen := map[string]bool{}
+	for _, m := range group.Members {
+		properties := map[string]json.RawMessage{}
+		if err := json.Unmarshal(m, &properties); err != nil {
+			continue
+		}
+
+		link := ""
+		if err := json.Unmarshal(properties["@odata.id"], &link); err != nil || link == "" {
+			continue
+		}
+
+		if seen[link] || (filter != nil && !filter(link)) {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+
+		// A member that was not expanded only holds its link
+		if len(properties) > 1 {
+			expanded[link] = m
+		}
+	}
+
+	return links, expanded, true
+}
@@ -2,14 +2,20 @@ package collector
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	neturl "net/url"
+	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/firmus-public/oob_gpu_exporter/internal/config"
@@ -17,37 +23,149 @@ import (
 )
 
 type Redfish struct {
-	http     *http.Client
-	baseurl  string
-	hostname string
-	username string
-	password string
-	session  struct {
+	http       *http.Client
+	requests   atomic.Uint64
+	certExpiry atomic.Int64
+	baseurl    string
+	hostname   string
+	username   string
+	password   string
+	slots      chan struct{}
+	session    struct {
 		disabled bool
 		id       string
 		token    string
 	}
+	retry struct {
+		max        int
+		backoff    time.Duration
+		maxBackoff time.Duration
+	}
 }
 
 const redfishRootPath = "/redfish/v1"
 
-func NewRedfish(scheme, hostname, username, password string) *Redfish {
-	return &Redfish{
-		baseurl:  fmt.Sprintf("%s://%s", scheme, hostname),
-		hostname: hostname,
-		username: username,
-		password: password,
-		http: &http.Client{
-			Transport: &http.Transport{
-				Proxy:           http.ProxyFromEnvironment,
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+// Statuses of responses to GET requests that are retried, which BMCs answer
+// with while they are busy
+var retryStatus = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// Counts the requests sent to the host and records their duration and status
+type instrumentedTransport struct {
+	next     http.RoundTripper
+	target   string
+	requests *atomic.Uint64
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	code := 0
+	if err == nil {
+		code = resp.StatusCode
+	}
+	observeRequest(t.target, req.URL.Path, code, time.Since(start))
+
+	return resp, err
+}
+
+// Closes the idle connections of the underlying transport, which is what
+// http.Client.CloseIdleConnections calls
+func (t *instrumentedTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+func NewRedfish(h *config.HostConfig) (*Redfish, error) {
+	r := &Redfish{
+		baseurl:  fmt.Sprintf("%s://%s", h.Scheme, h.Hostname),
+		hostname: h.Hostname,
+		username: h.Username,
+		password: h.Password,
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: h.SkipVerify(),
+		ServerName:         h.ServerName,
+		VerifyConnection:   r.verifyConnection(h),
+	}
+
+	if h.CAFile != "" {
+		pem, err := os.ReadFile(h.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file %s", h.CAFile)
+		}
+	}
+
+	cfg := config.Current()
+
+	// The global proxy only applies to https like the HTTPS_PROXY variable
+	proxy := http.ProxyFromEnvironment
+	proxyURL := h.ProxyURL
+	if proxyURL == "" && h.Scheme == "https" {
+		proxyURL = cfg.HttpsProxy
+	}
+	if proxyURL != "" {
+		u, err := neturl.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy url: %v", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = cfg.Timeout
+	}
+
+	// The requests in flight are bounded for the host as a whole, since the
+	// resources of GPUs are walked in pools nested in the pool of the GPUs
+	concurrency := h.Concurrency
+	if concurrency == 0 {
+		concurrency = cfg.Concurrency
+	}
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	r.slots = make(chan struct{}, concurrency)
+
+	r.http = &http.Client{
+		Transport: &instrumentedTransport{
+			next: &http.Transport{
+				Proxy:           proxy,
+				TLSClientConfig: tlsConfig,
 			},
-			Timeout: time.Duration(config.Config.Timeout) * time.Second,
+			target:   h.Hostname,
+			requests: &r.requests,
 		},
+		Timeout: time.Duration(timeout) * time.Second,
 	}
+
+	r.retry.max = int(cfg.Retries)
+	r.retry.backoff = time.Duration(cfg.RetryBackoff * float64(time.Second))
+	r.retry.maxBackoff = time.Duration(cfg.RetryMaxBackoff * float64(time.Second))
+
+	return r, nil
+}
+
+// Returns the total number of requests sent to the host
+func (r *Redfish) Requests() uint64 {
+	return r.requests.Load()
 }
 
-func (r *Redfish) CreateSession() bool {
+func (r *Redfish) CreateSession(ctx context.Context) bool {
 	url := fmt.Sprintf("%s/redfish/v1/SessionService/Sessions", r.baseurl)
 	session := Session{
 		Username: r.username,
@@ -55,7 +173,7 @@ func (r *Redfish) CreateSession() bool {
 	}
 	body, _ := json.Marshal(&session)
 
-	resp, err := r.http.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := r.post(ctx, url, body)
 	defer func() {
 		if resp != nil {
 			err = resp.Body.Close()
@@ -83,9 +201,10 @@ func (r *Redfish) CreateSession() bool {
 		}
 
 		url = fmt.Sprintf("%s/redfish/v1/Sessions", r.baseurl)
-		resp, err = r.http.Post(url, "application/json", bytes.NewBuffer(body))
+		resp, err = r.post(ctx, url, body)
 		if err != nil {
-			r.session.disabled = true
+			// A scrape that timed out says nothing about the host
+			r.session.disabled = ctx.Err() == nil
 			return false
 		}
 	}
@@ -101,6 +220,11 @@ func (r *Redfish) CreateSession() bool {
 		return false
 	}
 
+	// A session replacing an expired one is not counted again
+	if len(r.session.token) == 0 {
+		openSessions.Add(1)
+	}
+
 	r.session.id = session.OdataId
 	r.session.token = resp.Header.Get("X-Auth-Token")
 
@@ -116,13 +240,23 @@ func (r *Redfish) CreateSession() bool {
 	return true
 }
 
-func (r *Redfish) DeleteSession() bool {
+// Posts the credentials to create a session
+func (r *Redfish) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return r.http.Do(req)
+}
+
+func (r *Redfish) DeleteSession(ctx context.Context) bool {
 	if len(r.session.token) == 0 {
 		return true
 	}
 
 	url := fmt.Sprintf("%s%s", r.baseurl, r.session.id)
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return false
 	}
@@ -148,13 +282,14 @@ func (r *Redfish) DeleteSession() bool {
 	}
 
 	log.Debug("Succesfully deleted session: %s", path.Base(r.session.id))
+	openSessions.Add(-1)
 	r.session.id = ""
 	r.session.token = ""
 
 	return true
 }
 
-func (r *Redfish) RefreshSession() bool {
+func (r *Redfish) RefreshSession(ctx context.Context) bool {
 	if r.session.disabled {
 		return false
 	}
@@ -166,15 +301,15 @@ func (r *Redfish) RefreshSession() bool {
 	}()
 
 	if len(r.session.token) == 0 {
-		ok := r.CreateSession()
-		if !ok {
+		ok := r.CreateSession(ctx)
+		if !ok && ctx.Err() == nil {
 			r.session.disabled = true
 		}
 		return ok
 	}
 
 	url := fmt.Sprintf("%s%s", r.baseurl, r.session.id)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return false
 	}
@@ -193,10 +328,11 @@ func (r *Redfish) RefreshSession() bool {
 		}
 	}
 	if resp.StatusCode == http.StatusUnauthorized {
-		if r.CreateSession() {
+		if r.CreateSession(ctx) {
 			return true
 		} else {
-			r.session.disabled = true
+			openSessions.Add(-1)
+			r.session.disabled = ctx.Err() == nil
 			r.session.token = ""
 			r.session.id = ""
 			return false
@@ -206,26 +342,20 @@ func (r *Redfish) RefreshSession() bool {
 	return true
 }
 
-func (r *Redfish) Get(path string, res any) bool {
+func (r *Redfish) Get(ctx context.Context, path string, res any) bool {
 	if !strings.HasPrefix(path, redfishRootPath) {
 		return false
 	}
 
 	url := fmt.Sprintf("%s%s", r.baseurl, path)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
+	if !r.acquire(ctx) {
+		log.Error("Failed to query %q: %v", url, ctx.Err())
 		return false
 	}
-
-	req.Header.Add("Accept", "application/json")
-	if len(r.session.token) > 0 {
-		req.Header.Set("X-Auth-Token", r.session.token)
-	} else {
-		req.SetBasicAuth(r.username, r.password)
-	}
+	defer r.release()
 
 	log.Debug("Querying %q", url)
-	resp, err := r.http.Do(req)
+	resp, err := r.get(ctx, url)
 	if resp != nil {
 		defer func() {
 			err = resp.Body.Close()
@@ -263,17 +393,125 @@ func (r *Redfish) Get(path string, res any) bool {
 	return true
 }
 
-func (r *Redfish) Exists(path string) bool {
+// Waits for one of the slots of the host, which bound the concurrent requests
+// to it, returns false if the context is done first
+func (r *Redfish) acquire(ctx context.Context) bool {
+	select {
+	case r.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (r *Redfish) release() {
+	<-r.slots
+}
+
+// Sends a GET request, which is retried after a connection error or a status
+// in retryStatus with an exponential backoff and jitter. The Retry-After of a
+// 429 or 503 response is honored. No retry is made if it would not complete
+// before the deadline of the context.
+func (r *Redfish) get(ctx context.Context, url string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Add("Accept", "application/json")
+		if len(r.session.token) > 0 {
+			req.Header.Set("X-Auth-Token", r.session.token)
+		} else {
+			req.SetBasicAuth(r.username, r.password)
+		}
+
+		resp, err := r.http.Do(req)
+		if attempt >= r.retry.max || ctx.Err() != nil || (err == nil && !retryStatus[resp.StatusCode]) {
+			return resp, err
+		}
+
+		wait := r.backoff(attempt)
+		reason := fmt.Sprint(err)
+		if err == nil {
+			if after, ok := retryAfter(resp); ok && after > wait {
+				wait = after
+			}
+			reason = resp.Status
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		log.Debug("Retrying %q in %v after %s", url, wait, reason)
+		observeRetry(r.hostname)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Returns the backoff before the given retry, which doubles with every retry
+// up to the maximum and is randomized by half to spread the retries of hosts
+func (r *Redfish) backoff(attempt int) time.Duration {
+	d := r.retry.backoff
+	for i := 0; i < attempt && d < r.retry.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.retry.maxBackoff {
+		d = r.retry.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Returns the wait requested by the Retry-After header of a 429 or 503
+// response, given either in seconds or as a date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+func (r *Redfish) Exists(ctx context.Context, path string) bool {
 	if !strings.HasPrefix(path, redfishRootPath) {
 		return false
 	}
 
 	url := fmt.Sprintf("%s%s", r.baseurl, path)
-	req, err := http.NewRequest("HEAD", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return false
 	}
 
+	if !r.acquire(ctx) {
+		return false
+	}
+	defer r.release()
+
 	req.Header.Add("Accept", "application/json")
 	if len(r.session.token) > 0 {
 		req.Header.Set("X-Auth-Token", r.session.token)
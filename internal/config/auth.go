@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Endpoints of the exporter that can require authentication
+var authEndpoints = map[string]bool{
+	"/":                 true,
+	"/metrics":          true,
+	"/exporter_metrics": true,
+	"/reset":            true,
+	"/health":           true,
+	"/-/reload":         true,
+}
+
+// Returns whether requests have to be authenticated at all
+func (a *AuthConfig) Enabled() bool {
+	return len(a.BasicAuthUsers) > 0 || len(a.BearerTokens) > 0
+}
+
+// Returns whether requests for the endpoint have to be authenticated
+func (a *AuthConfig) Required(endpoint string) bool {
+	if !a.Enabled() {
+		return false
+	}
+	for _, e := range a.Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AuthConfig) validate() error {
+	for user, hash := range a.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("invalid bcrypt hash for basic auth user: %s", user)
+		}
+	}
+
+	for i, token := range a.BearerTokens {
+		a.BearerTokens[i] = expandEnv(token)
+		if a.BearerTokens[i] == "" {
+			return fmt.Errorf("empty bearer token")
+		}
+	}
+
+	// All endpoints except the health check require authentication by default
+	if a.Endpoints == nil {
+		a.Endpoints = []string{"/", "/metrics", "/exporter_metrics", "/reset"}
+	}
+
+	for _, e := range a.Endpoints {
+		if !authEndpoints[e] {
+			return fmt.Errorf("unknown endpoint for authentication: %s", e)
+		}
+	}
+
+	return nil
+}
@@ -1,53 +1,151 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"github.com/firmus-public/oob_gpu_exporter/internal/log"
 	"gopkg.in/yaml.v3"
 )
 
 var Debug bool = false
-var Config *RootConfig = nil
 
+// The current configuration, which is replaced as a whole on reload and not
+// modified once it is set, so that it can be read without a lock
+var current atomic.Pointer[RootConfig]
+
+// Returns the current configuration
+func Current() *RootConfig {
+	return current.Load()
+}
+
+// Returns the configuration of the given target, which is a copy of the host
+// entry it resolves to unless the target has an entry of its own
 func GetHostConfig(target string) *HostConfig {
-	Config.Mutex.Lock()
-	defer Config.Mutex.Unlock()
-
-	host, ok := Config.Hosts[target]
-	if !ok {
-		def, ok := Config.Hosts["default"]
-		if !ok {
-			log.Error("Could not find login information for host: %s", target)
-			return nil
-		}
-		host = &HostConfig{
-			Hostname: target,
-			Scheme:   def.Scheme,
-			Username: def.Username,
-			Password: def.Password,
-		}
-		Config.Hosts[target] = host
+	host := Current().HostConfig(target)
+	if host == nil {
+		log.Error("Could not find login information for host: %s", target)
+	}
+
+	return host
+}
+
+// Returns whether metrics may be collected from the target
+func TargetAllowed(target string) bool {
+	return Current().TargetAllowed(target)
+}
+
+// Same as GetHostConfig for the given configuration
+func (c *RootConfig) HostConfig(target string) *HostConfig {
+	key := c.ResolveHost(target)
+	creds, ok := c.targetCredentials(target)
+	if key == "" && !ok {
+		return nil
+	}
+
+	host := c.Hosts[key]
+	if key == "" {
+		// Targets with credentials of their own do not need a host entry,
+		// without the default entry they use the global settings like a host
+		// entry that only has credentials
+		host = &HostConfig{Hostname: target, Scheme: "https", CAFile: c.CAFile}
+	}
+
+	if key != target || ok {
+		h := *host
+		h.Hostname = target
+		host = &h
+	}
+
+	if ok {
+		host.Username = creds.username
+		host.Password = creds.password
+	}
+
+	if host.Username == "" || host.Password == "" {
+		return nil
 	}
 
 	return host
 }
 
+// Returns whether the settings used to connect to and collect from the host
+// differ, in which case its collector has to be reset
+func (h *HostConfig) ClientChanged(o *HostConfig) bool {
+	return h.Username != o.Username ||
+		h.Password != o.Password ||
+		h.Scheme != o.Scheme ||
+		h.Concurrency != o.Concurrency ||
+		!h.Metrics.Equal(o.Metrics) ||
+		h.Timeout != o.Timeout ||
+		h.ProxyURL != o.ProxyURL ||
+		h.CAFile != o.CAFile ||
+		h.SkipVerify() != o.SkipVerify() ||
+		h.ServerName != o.ServerName ||
+		h.Fingerprint != o.Fingerprint ||
+		h.TrustOnFirstUse != o.TrustOnFirstUse
+}
+
+// Returns whether the global settings used to connect to and collect from the
+// hosts differ, in which case all collectors have to be reset
+func (c *RootConfig) ClientChanged(o *RootConfig) bool {
+	return c.MetricsPrefix != o.MetricsPrefix ||
+		c.Timeout != o.Timeout ||
+		c.HttpsProxy != o.HttpsProxy ||
+		c.Concurrency != o.Concurrency ||
+		c.Retries != o.Retries ||
+		c.RetryBackoff != o.RetryBackoff ||
+		c.RetryMaxBackoff != o.RetryMaxBackoff ||
+		!c.Metrics.Equal(o.Metrics) ||
+		c.Fingerprints != o.Fingerprints
+}
+
+// Returns whether the settings of the listener differ, which can only be
+// applied by a restart
+func (c *RootConfig) ListenerChanged(o *RootConfig) bool {
+	return c.Address != o.Address ||
+		c.Port != o.Port ||
+		c.TLS.Enabled != o.TLS.Enabled
+}
+
+// Returns whether the certificate of the host is not verified against a CA,
+// which is only done by default if no CA file is given since most BMCs use
+// self-signed certificates. Hosts identified by the fingerprint of their
+// certificate are not verified against a CA either.
+func (h *HostConfig) SkipVerify() bool {
+	if h.Fingerprint != "" || h.TrustOnFirstUse {
+		return true
+	}
+	if h.InsecureSkipVerify != nil {
+		return *h.InsecureSkipVerify
+	}
+	return h.CAFile == ""
+}
+
+// Returns a SHA-256 fingerprint given as hex string with optional colons in
+// lower case without colons
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// Returns a configuration with the defaults of settings for which 0 is a
+// valid value, so that they only apply when the setting is absent
 func NewConfig() *RootConfig {
 	return &RootConfig{
-		Hosts: make(map[string]*HostConfig),
+		Hosts:                make(map[string]*HostConfig),
+		ScrapeTimeoutOffset:  0.5,
+		RetryBackoff:         0.5,
+		RetryMaxBackoff:      5,
+		CollectorIdleTimeout: 1800,
 	}
 }
 
 func SetConfig(c *RootConfig) {
-	Config = c
-	if c.HttpsProxy != "" {
-		err := os.Setenv("HTTPS_PROXY", c.HttpsProxy)
-		if err != nil {
-			log.Error("Failed to set HTTPS_PROXY environment variable: %v", err)
-		}
-	}
+	current.Store(c)
 }
 
 func (c *RootConfig) FromFile(filename string) error {
@@ -82,12 +180,46 @@ func (c *RootConfig) Validate() error {
 		c.Timeout = 10
 	}
 
+	if c.Concurrency == 0 {
+		c.Concurrency = 4
+	}
+
+	if c.MaxStaleness == 0 {
+		c.MaxStaleness = 300
+	}
+
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = 10
+	}
+
+	if c.ScrapeTimeoutOffset < 0 {
+		return fmt.Errorf("negative scrape_timeout_offset: %v", c.ScrapeTimeoutOffset)
+	}
+
+	if c.RetryBackoff < 0 || c.RetryMaxBackoff < 0 {
+		return fmt.Errorf("negative retry_backoff or retry_max_backoff")
+	}
+
 	if c.MetricsPrefix == "" {
 		c.MetricsPrefix = "oob"
 	}
 
-	// hosts section
-	if len(c.Hosts) == 0 {
+	if err := c.Metrics.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+
+	c.Credentials = expandEnv(c.Credentials)
+	if err := c.LoadCredentials(); err != nil {
+		return err
+	}
+
+	// hosts section, which may be empty if all targets have their
+	// credentials in the credentials directory
+	if len(c.Hosts) == 0 && c.Credentials == "" {
 		return fmt.Errorf("empty section: hosts")
 	}
 
@@ -95,10 +227,15 @@ func (c *RootConfig) Validate() error {
 		if v == nil {
 			return fmt.Errorf("missing username and password for host: %s", k)
 		}
-		if v.Username == "" {
+		if err := v.loadCredentials(); err != nil {
+			return fmt.Errorf("%v for host: %s", err, k)
+		}
+		// Credentials can also be given for each target in the
+		// credentials directory
+		if v.Username == "" && c.Credentials == "" {
 			return fmt.Errorf("missing username for host: %s", k)
 		}
-		if v.Password == "" {
+		if v.Password == "" && c.Credentials == "" {
 			return fmt.Errorf("missing password for host: %s", k)
 		}
 
@@ -110,8 +247,52 @@ func (c *RootConfig) Validate() error {
 			return fmt.Errorf("invalid scheme for host: %s", k)
 		}
 
+		if err := v.Metrics.validate(); err != nil {
+			return fmt.Errorf("%v for host: %s", err, k)
+		}
+
+		if v.ProxyURL != "" {
+			if _, err := url.Parse(v.ProxyURL); err != nil {
+				return fmt.Errorf("invalid proxy url for host: %s", k)
+			}
+		}
+
+		if v.CAFile == "" {
+			v.CAFile = c.CAFile
+		}
+
+		if v.CAFile != "" {
+			if _, err := os.Stat(v.CAFile); err != nil {
+				return fmt.Errorf("invalid ca file for host: %s: %v", k, err)
+			}
+		}
+
+		if v.Fingerprint != "" {
+			b, err := hex.DecodeString(NormalizeFingerprint(v.Fingerprint))
+			if err != nil || len(b) != 32 {
+				return fmt.Errorf("invalid SHA-256 fingerprint for host: %s", k)
+			}
+		}
+
+		if v.TrustOnFirstUse && c.Fingerprints == "" {
+			return fmt.Errorf("trust on first use without fingerprints file for host: %s", k)
+		}
+
+		if v.PollInterval > 0 && HostKind(k) != HostExact {
+			return fmt.Errorf("poll interval requires a single host: %s", k)
+		}
+
+		if v.PollInterval > c.MaxStaleness {
+			return fmt.Errorf("poll interval exceeds max staleness for host: %s", k)
+		}
+
 		v.Hostname = k
 	}
 
-	return nil
+	err := c.indexAllowedTargets()
+	if err != nil {
+		return err
+	}
+
+	return c.IndexHosts()
 }
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Username and password of a target read from the credentials directory
+type credentials struct {
+	username string
+	password string
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Replaces references like ${NAME} with the value of the environment variable,
+// undefined variables are replaced with an empty string like in a shell
+func expandEnv(s string) string {
+	return envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+	})
+}
+
+// Reads a file holding a secret, a trailing newline is not part of it
+func readSecret(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Reads a file holding user=pass like the keys of a Kubernetes secret
+func readCredentials(filename string) (credentials, error) {
+	secret, err := readSecret(filename)
+	if err != nil {
+		return credentials{}, err
+	}
+
+	username, password, ok := strings.Cut(secret, "=")
+	if !ok || username == "" || password == "" {
+		return credentials{}, fmt.Errorf("expected user=pass in credentials file: %s", filename)
+	}
+
+	return credentials{username: username, password: password}, nil
+}
+
+// Returns whether the path exists and is a regular file, symbolic links are
+// followed as secrets are mounted as links to a hidden directory
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Resolves the environment references and credential files of a host entry
+func (h *HostConfig) loadCredentials() error {
+	h.Username = expandEnv(h.Username)
+	h.Password = expandEnv(h.Password)
+	h.PasswordFile = expandEnv(h.PasswordFile)
+	h.CredentialsFile = expandEnv(h.CredentialsFile)
+
+	if h.PasswordFile != "" {
+		if h.Password != "" {
+			return fmt.Errorf("both password and password file")
+		}
+		password, err := readSecret(h.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("read password file: %v", err)
+		}
+		h.Password = password
+	}
+
+	// The credentials file is optional and overrides the username and password
+	if h.CredentialsFile != "" && isFile(h.CredentialsFile) {
+		creds, err := readCredentials(h.CredentialsFile)
+		if err != nil {
+			return err
+		}
+		h.Username = creds.username
+		h.Password = creds.password
+	}
+
+	return nil
+}
+
+// Reads the credentials directory, in which each file is named after a target
+// and holds user=pass. Hidden files are skipped, which includes the data
+// directories of a mounted Kubernetes secret.
+func (c *RootConfig) LoadCredentials() error {
+	c.secrets = nil
+	if c.Credentials == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(c.Credentials)
+	if err != nil {
+		return fmt.Errorf("read credentials directory: %v", err)
+	}
+
+	c.secrets = map[string]credentials{}
+	for _, e := range entries {
+		path := filepath.Join(c.Credentials, e.Name())
+		if strings.HasPrefix(e.Name(), ".") || !isFile(path) {
+			continue
+		}
+
+		creds, err := readCredentials(path)
+		if err != nil {
+			return err
+		}
+		c.secrets[e.Name()] = creds
+	}
+
+	return nil
+}
+
+// Returns the credentials of the target from the credentials directory, files
+// can be named after the target or after its host without the port
+func (c *RootConfig) targetCredentials(target string) (credentials, bool) {
+	if creds, ok := c.secrets[target]; ok {
+		return creds, true
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		creds, ok := c.secrets[strings.Trim(host, "[]")]
+		return creds, ok
+	}
+	return credentials{}, false
+}
+
+// Returns the directories holding credentials, which are watched for changes
+func (c *RootConfig) CredentialPaths() []string {
+	dirs := map[string]bool{}
+	if c.Credentials != "" {
+		dirs[c.Credentials] = true
+	}
+	for _, h := range c.Hosts {
+		if h.PasswordFile != "" {
+			dirs[filepath.Dir(h.PasswordFile)] = true
+		}
+		if h.CredentialsFile != "" {
+			dirs[filepath.Dir(h.CredentialsFile)] = true
+		}
+	}
+
+	paths := []string{}
+	for d := range dirs {
+		paths = append(paths, d)
+	}
+	return paths
+}
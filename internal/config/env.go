@@ -41,6 +41,18 @@ func getEnvUint(env string, val *uint) {
 	}
 }
 
+func getEnvFloat(env string, val *float64) {
+	s := os.Getenv(env)
+	if len(s) == 0 {
+		return
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		*val = value
+	}
+}
+
 func (c *RootConfig) FromEnvironment() {
 	var username string
 	var password string
@@ -53,11 +65,29 @@ func (c *RootConfig) FromEnvironment() {
 	getEnvString("CONFIG_DEFAULT_SCHEME", &scheme)
 	getEnvString("CONFIG_TLS_CERT_FILE", &c.TLS.CertFile)
 	getEnvString("CONFIG_TLS_KEY_FILE", &c.TLS.KeyFile)
+	getEnvString("CONFIG_TLS_CLIENT_CA_FILE", &c.TLS.ClientCAFile)
+	getEnvString("CONFIG_TLS_CLIENT_AUTH_TYPE", &c.TLS.ClientAuthType)
+	getEnvString("CONFIG_TLS_MIN_VERSION", &c.TLS.MinVersion)
+	getEnvString("CONFIG_CA_FILE", &c.CAFile)
+	getEnvString("CONFIG_FINGERPRINTS_FILE", &c.Fingerprints)
+	getEnvString("CONFIG_CREDENTIALS_DIR", &c.Credentials)
+	getEnvString("CONFIG_RELOAD_TOKEN", &c.ReloadToken)
 
 	getEnvUint("CONFIG_PORT", &c.Port)
 	getEnvUint("CONFIG_TIMEOUT", &c.Timeout)
+	getEnvUint("CONFIG_MAX_STALENESS", &c.MaxStaleness)
+	getEnvUint("CONFIG_SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	getEnvUint("CONFIG_COLLECTOR_IDLE_TIMEOUT", &c.CollectorIdleTimeout)
+	getEnvUint("CONFIG_MAX_COLLECTORS", &c.MaxCollectors)
+	getEnvUint("CONFIG_CONCURRENCY", &c.Concurrency)
+	getEnvUint("CONFIG_RETRIES", &c.Retries)
+
+	getEnvFloat("CONFIG_SCRAPE_TIMEOUT_OFFSET", &c.ScrapeTimeoutOffset)
+	getEnvFloat("CONFIG_RETRY_BACKOFF", &c.RetryBackoff)
+	getEnvFloat("CONFIG_RETRY_MAX_BACKOFF", &c.RetryMaxBackoff)
 
 	getEnvBool("CONFIG_TLS_ENABLED", &c.TLS.Enabled)
+	getEnvBool("CONFIG_STRICT_TARGETS", &c.StrictTargets)
 
 	def, ok := c.Hosts["default"]
 	if !ok {
@@ -71,6 +101,7 @@ func (c *RootConfig) FromEnvironment() {
 
 	if len(password) > 0 {
 		def.Password = password
+		def.PasswordFile = ""
 		ok = true
 	}
 
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of host entries in the order of their precedence
+const (
+	HostExact = iota
+	HostCIDR
+	HostRegexp
+	HostGlob
+	HostDefault
+)
+
+var hostKinds = []string{"exact", "cidr", "regexp", "glob", "default"}
+
+// A host entry whose key matches several targets
+type hostPattern struct {
+	key    string
+	kind   int
+	cidr   *net.IPNet
+	regexp *regexp.Regexp
+}
+
+// Returns the kind of the host entry with the given key. Keys starting with a
+// tilde are regular expressions, keys containing any of *?[ are globs unless
+// they are IPv6 addresses like [2001:db8::1]:443 and keys like 10.20.0.0/16
+// are CIDR ranges.
+func HostKind(key string) int {
+	switch {
+	case key == "default":
+		return HostDefault
+	case strings.HasPrefix(key, "~"):
+		return HostRegexp
+	case isAddress(key):
+		return HostExact
+	case strings.ContainsAny(key, "*?["):
+		return HostGlob
+	}
+
+	if _, _, err := net.ParseCIDR(key); err == nil {
+		return HostCIDR
+	}
+
+	return HostExact
+}
+
+// Returns whether the key is an IP address with optional port, whose brackets
+// are part of the address and not of a glob
+func isAddress(key string) bool {
+	host := key
+	if h, _, err := net.SplitHostPort(key); err == nil {
+		host = h
+	}
+	_, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	return err == nil
+}
+
+// Returns the name of the kind of a host entry
+func HostKindName(kind int) string {
+	return hostKinds[kind]
+}
+
+// Compiles the pattern of a host entry or allowed target
+func newHostPattern(key string) (*hostPattern, error) {
+	p := &hostPattern{key: key, kind: HostKind(key)}
+
+	switch p.kind {
+	case HostCIDR:
+		_, p.cidr, _ = net.ParseCIDR(key)
+	case HostRegexp:
+		re, err := regexp.Compile("^(?:" + strings.TrimPrefix(key, "~") + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		p.regexp = re
+	case HostGlob:
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob: %v", err)
+		}
+	}
+
+	return p, nil
+}
+
+// Compiles the pattern host entries and orders them by precedence: CIDR ranges
+// from the longest to the shortest prefix, then regular expressions and then
+// globs from the longest to the shortest pattern
+func (c *RootConfig) IndexHosts() error {
+	patterns := []*hostPattern{}
+
+	for k := range c.Hosts {
+		kind := HostKind(k)
+		if kind == HostExact || kind == HostDefault {
+			continue
+		}
+
+		p, err := newHostPattern(k)
+		if err != nil {
+			return fmt.Errorf("%v for host: %s", err, k)
+		}
+
+		patterns = append(patterns, p)
+	}
+
+	sort.Slice(patterns, func(i, j int) bool {
+		a, b := patterns[i], patterns[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.kind == HostCIDR {
+			ai, _ := a.cidr.Mask.Size()
+			bi, _ := b.cidr.Mask.Size()
+			if ai != bi {
+				return ai > bi
+			}
+		}
+		if a.kind == HostGlob && len(a.key) != len(b.key) {
+			return len(a.key) > len(b.key)
+		}
+		return a.key < b.key
+	})
+
+	c.patterns = patterns
+	return nil
+}
+
+// Compiles the allowed targets, which use the same syntax as host entries
+func (c *RootConfig) indexAllowedTargets() error {
+	c.allowed = nil
+
+	for _, t := range c.AllowedTargets {
+		p, err := newHostPattern(t)
+		if err != nil {
+			return fmt.Errorf("%v for allowed target: %s", err, t)
+		}
+		c.allowed = append(c.allowed, p)
+	}
+
+	return nil
+}
+
+// Returns whether metrics may be collected from the target. In strict mode
+// only targets with a host entry other than the default one, credentials of
+// their own or matching an allowed target are, so that the default
+// credentials are not sent to arbitrary hosts.
+func (c *RootConfig) TargetAllowed(target string) bool {
+	if !c.StrictTargets && len(c.allowed) == 0 {
+		return true
+	}
+
+	for _, p := range c.allowed {
+		if p.match(target) {
+			return true
+		}
+	}
+
+	if key := c.ResolveHost(target); key != "" && key != "default" {
+		return true
+	}
+
+	_, ok := c.targetCredentials(target)
+	return ok
+}
+
+// Returns whether the target matches the pattern. Targets may include a port,
+// which is only compared by exact keys that include one, all other kinds of
+// patterns match the host of the target.
+func (p *hostPattern) match(target string) bool {
+	host := target
+	if h, _, err := net.SplitHostPort(target); err == nil {
+		host = strings.Trim(h, "[]")
+	}
+
+	switch p.kind {
+	case HostExact, HostDefault:
+		return p.key == target || p.key == host
+	case HostCIDR:
+		ip := net.ParseIP(host)
+		return ip != nil && p.cidr.Contains(ip)
+	case HostRegexp:
+		return p.regexp.MatchString(host)
+	case HostGlob:
+		ok, _ := path.Match(p.key, host)
+		return ok
+	}
+	return false
+}
+
+// Returns the key of the host entry the target resolves to, which is an exact
+// match, the first matching pattern or the default entry. The key is empty if
+// no entry matches.
+func (c *RootConfig) ResolveHost(target string) string {
+	if _, ok := c.Hosts[target]; ok && HostKind(target) == HostExact {
+		return target
+	}
+
+	for _, p := range c.patterns {
+		if p.match(target) {
+			return p.key
+		}
+	}
+
+	if _, ok := c.Hosts["default"]; ok {
+		return "default"
+	}
+
+	return ""
+}
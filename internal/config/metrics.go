@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Groups of metrics that can be selected in the metrics section
+const (
+	MetricsAll         = "all"
+	MetricsInventory   = "inventory"
+	MetricsHealth      = "health"
+	MetricsThermal     = "thermal"
+	MetricsPower       = "power"
+	MetricsUtilization = "utilization"
+	MetricsPCIe        = "pcie"
+	MetricsNVLink      = "nvlink"
+	MetricsNvidiaOem   = "nvidia_oem"
+	MetricsDellOem     = "dell_oem"
+)
+
+var metricGroups = map[string]bool{
+	MetricsAll:         true,
+	MetricsInventory:   true,
+	MetricsHealth:      true,
+	MetricsThermal:     true,
+	MetricsPower:       true,
+	MetricsUtilization: true,
+	MetricsPCIe:        true,
+	MetricsNVLink:      true,
+	MetricsNvidiaOem:   true,
+	MetricsDellOem:     true,
+}
+
+// Selection of metric groups, all groups are selected if none is
+type MetricsConfig map[string]bool
+
+// Returns whether any of the given groups is selected
+func (m MetricsConfig) Enabled(groups ...string) bool {
+	if m.empty() || m[MetricsAll] {
+		return true
+	}
+
+	for _, g := range groups {
+		if m[g] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns whether both selections select the same groups, a missing selection
+// is only equal to another missing one as it is inherited
+func (m MetricsConfig) Equal(o MetricsConfig) bool {
+	if (m == nil) != (o == nil) {
+		return false
+	}
+	for g := range metricGroups {
+		if m.Enabled(g) != o.Enabled(g) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the selected groups separated by commas
+func (m MetricsConfig) String() string {
+	if m.Enabled(MetricsAll) {
+		return MetricsAll
+	}
+
+	groups := []string{}
+	for g, v := range m {
+		if v {
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+
+	return strings.Join(groups, ",")
+}
+
+func (m MetricsConfig) empty() bool {
+	for _, v := range m {
+		if v {
+			return false
+		}
+	}
+	return true
+}
+
+func (m MetricsConfig) validate() error {
+	for g := range m {
+		if !metricGroups[g] {
+			return fmt.Errorf("unknown metrics group: %s", g)
+		}
+	}
+	return nil
+}
@@ -1,27 +1,97 @@
 package config
 
-import "sync"
-
 type HostConfig struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	Scheme   string `yaml:"scheme"`
-	Hostname string
+	Username     string        `yaml:"username"`
+	Password     string        `yaml:"password"`
+	Scheme       string        `yaml:"scheme"`
+	PollInterval uint          `yaml:"poll_interval"`
+	Concurrency  uint          `yaml:"concurrency"`
+	Metrics      MetricsConfig `yaml:"metrics"`
+	Hostname     string
+
+	// Credentials read from files
+	PasswordFile    string `yaml:"password_file"`
+	CredentialsFile string `yaml:"credentials_file"`
+
+	// Connection overrides
+	Timeout            uint   `yaml:"timeout"`
+	ProxyURL           string `yaml:"proxy_url"`
+	CAFile             string `yaml:"ca_file"`
+	InsecureSkipVerify *bool  `yaml:"insecure_skip_verify"`
+	ServerName         string `yaml:"server_name"`
+	Fingerprint        string `yaml:"fingerprint"`
+	TrustOnFirstUse    bool   `yaml:"trust_on_first_use"`
 }
 
 type TLSConfig struct {
 	Enabled  bool   `yaml:"enabled"`
 	CertFile string `yaml:"cert_file"`
 	KeyFile  string `yaml:"key_file"`
+
+	// Client certificates and protocol settings like in the web configuration
+	// of the Prometheus exporter toolkit
+	ClientCAFile          string   `yaml:"client_ca_file"`
+	ClientAuthType        string   `yaml:"client_auth_type"`
+	ClientAllowedSANs     []string `yaml:"client_allowed_sans"`
+	ClientAllowedSubjects []string `yaml:"client_allowed_subjects"`
+	MinVersion            string   `yaml:"min_version"`
+	CipherSuites          []string `yaml:"cipher_suites"`
+}
+
+// Users and tokens that requests to the endpoints of the exporter are
+// authenticated with, passwords are bcrypt hashes
+type AuthConfig struct {
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+	BearerTokens   []string          `yaml:"bearer_tokens"`
+	Endpoints      []string          `yaml:"endpoints"`
 }
 
 type RootConfig struct {
-	Mutex         sync.Mutex
 	Address       string                 `yaml:"address"`
 	Port          uint                   `yaml:"port"`
 	HttpsProxy    string                 `yaml:"https_proxy"`
 	MetricsPrefix string                 `yaml:"metrics_prefix"`
 	TLS           TLSConfig              `yaml:"tls"`
+	Auth          AuthConfig             `yaml:"auth"`
 	Timeout       uint                   `yaml:"timeout"`
+	MaxStaleness  uint                   `yaml:"max_staleness"`
+	Concurrency   uint                   `yaml:"concurrency"`
+	Metrics       MetricsConfig          `yaml:"metrics"`
+	CAFile        string                 `yaml:"ca_file"`
+	Fingerprints  string                 `yaml:"fingerprints_file"`
+	Credentials   string                 `yaml:"credentials_dir"`
+	ReloadToken   string                 `yaml:"reload_token"`
 	Hosts         map[string]*HostConfig `yaml:"hosts"`
+
+	// Seconds to wait for ongoing scrapes and the deletion of the sessions of
+	// all hosts on shutdown
+	ShutdownTimeout uint `yaml:"shutdown_timeout"`
+
+	// Seconds subtracted from the scrape timeout sent by Prometheus, so that
+	// the partial results of a scrape running into it arrive in time
+	ScrapeTimeoutOffset float64 `yaml:"scrape_timeout_offset"`
+
+	// Retries of Redfish GET requests that failed with a connection error or
+	// while the host was busy and the backoff between them in seconds, which
+	// doubles with every retry up to the maximum
+	Retries         uint    `yaml:"retries"`
+	RetryBackoff    float64 `yaml:"retry_backoff"`
+	RetryMaxBackoff float64 `yaml:"retry_max_backoff"`
+
+	// Seconds after which the collector of a target that is not scraped is
+	// evicted and the maximum number of collectors, 0 means unlimited
+	CollectorIdleTimeout uint `yaml:"collector_idle_timeout"`
+	MaxCollectors        uint `yaml:"max_collectors"`
+
+	// Only allow targets with a host entry other than the default one or
+	// matching the allowed targets, which implies strict mode
+	StrictTargets  bool     `yaml:"strict_targets"`
+	AllowedTargets []string `yaml:"allowed_targets"`
+
+	// Host entries matching several targets in the order of their precedence
+	patterns []*hostPattern
+	allowed  []*hostPattern
+
+	// Credentials of targets read from the credentials directory
+	secrets map[string]credentials
 }
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// Returns the TLS configuration of the server without its certificate. Client
+// certificates are required and verified by default if a client CA is given.
+func (t *TLSConfig) ServerConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version: %s", t.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if len(t.CipherSuites) > 0 {
+		ids := map[string]uint16{}
+		for _, c := range tls.CipherSuites() {
+			ids[c.Name] = c.ID
+		}
+		for _, name := range t.CipherSuites {
+			id, ok := ids[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure cipher suite: %s", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	authType := t.ClientAuthType
+	if authType == "" && t.ClientCAFile != "" {
+		authType = "RequireAndVerifyClientCert"
+	}
+	if authType != "" {
+		clientAuth, ok := clientAuthTypes[authType]
+		if !ok {
+			return nil, fmt.Errorf("unknown client auth type: %s", authType)
+		}
+		cfg.ClientAuth = clientAuth
+	}
+
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA file: %v", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file: %s", t.ClientCAFile)
+		}
+	} else if cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client auth type %s requires a client CA file", authType)
+	}
+
+	// The names of a certificate can only be trusted if it was verified
+	if len(t.ClientAllowedSANs) > 0 || len(t.ClientAllowedSubjects) > 0 {
+		if cfg.ClientAuth != tls.VerifyClientCertIfGiven && cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+			return nil, fmt.Errorf("allowed client names require client auth type VerifyClientCertIfGiven or RequireAndVerifyClientCert")
+		}
+		cfg.VerifyConnection = t.verifyClient
+	}
+
+	return cfg, nil
+}
+
+// Verifies that the client certificate has an allowed subject alternative name
+// or subject, which is compared with the common name and the whole subject.
+// Clients without certificate are rejected, even if it is optional otherwise.
+func (t *TLSConfig) verifyClient(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("client certificate is required by the allowed client names")
+	}
+	cert := cs.PeerCertificates[0]
+
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, allowed := range t.ClientAllowedSANs {
+		for _, name := range names {
+			if name == allowed {
+				return nil
+			}
+		}
+	}
+
+	for _, allowed := range t.ClientAllowedSubjects {
+		if allowed == cert.Subject.CommonName || allowed == cert.Subject.String() {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("client certificate of %s is not allowed", cert.Subject)
+}